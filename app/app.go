@@ -3,25 +3,44 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/chi/v5"
 	c "github.com/jcardenasc93/work-at-olist/app/controllers"
 	"github.com/jcardenasc93/work-at-olist/app/db"
 	m "github.com/jcardenasc93/work-at-olist/app/middlewares"
+	"github.com/jcardenasc93/work-at-olist/app/render"
 )
 
+// maxBodySizeEnv is the environment variable holding the request body limit
+// in bytes.
+const maxBodySizeEnv = "maxBodySize"
+
+// defaultPageSizeEnv and maxPageSizeEnv are the environment variables holding
+// the page size of the lists when no limit is given, and the largest limit
+// accepted.
+const defaultPageSizeEnv = "defaultPageSize"
+const maxPageSizeEnv = "maxPageSize"
+
 type APIServer struct {
-	port       string
-	production bool
-	db         db.ApiDB
+	port            string
+	production      bool
+	db              db.ApiDB
+	maxBodySize     int64
+	defaultPageSize int
+	maxPageSize     int
 }
 
-func NewAPIServer(port string, production bool, db db.ApiDB) *APIServer {
+func NewAPIServer(port string, production bool, db db.ApiDB, maxBodySize int64, defaultPageSize int, maxPageSize int) *APIServer {
 	return &APIServer{
-		port:       port,
-		production: production,
-		db:         db,
+		port:            port,
+		production:      production,
+		db:              db,
+		maxBodySize:     maxBodySize,
+		defaultPageSize: defaultPageSize,
+		maxPageSize:     maxPageSize,
 	}
 }
 
@@ -29,14 +48,25 @@ func (s *APIServer) Run() {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	r.Use(m.Recoverer)
+	r.Use(m.MaxBodySize(s.maxBodySize))
+	// Set before the routes so the sub-routers inherit them
+	r.NotFound(render.NotFound)
+	r.MethodNotAllowed(render.MethodNotAllowed)
+	pagination := m.PaginationLimits(s.defaultPageSize, s.maxPageSize)
 
 	r.Route("/authors", func(r chi.Router) {
-		r.With(m.Pagination).Get("/", c.HTTPHandleFunc(c.GetAuthors, s.db))
+		r.With(pagination).Get("/", c.HTTPHandleFunc(c.GetAuthors, s.db))
 	})
 	r.Route("/books", func(r chi.Router) {
 		r.Post("/", c.HTTPHandleFunc(c.CreateBook, s.db))
-		r.With(m.Pagination).Get("/", c.HTTPHandleFunc(c.GetBooks, s.db))
+		r.With(pagination).Get("/", c.HTTPHandleFunc(c.GetBooks, s.db))
+		r.With(pagination).Get("/trash", c.HTTPHandleFunc(c.GetTrashedBooks, s.db))
+		r.Get("/{id}", c.HTTPHandleFunc(c.GetBook, s.db))
+		r.Put("/{id}", c.HTTPHandleFunc(c.ReplaceBook, s.db))
+		r.Patch("/{id}", c.HTTPHandleFunc(c.PatchBook, s.db))
+		r.Delete("/{id}", c.HTTPHandleFunc(c.DeleteBook, s.db))
+		r.Post("/{id}/restore", c.HTTPHandleFunc(c.RestoreBook, s.db))
 	})
 
 	log.Printf("Server active on port: %s", s.port)
@@ -54,6 +84,26 @@ func main() {
 	if err != nil {
 		log.Fatal("Couldn't initialize DB")
 	}
-	server := NewAPIServer(":8080", false, db)
+	maxBodySize := positiveEnv(maxBodySizeEnv, m.DefaultMaxBodySize)
+	defaultPageSize := positiveEnv(defaultPageSizeEnv, m.DefaultLimit)
+	maxPageSize := positiveEnv(maxPageSizeEnv, m.DefaultMaxLimit)
+	if defaultPageSize > maxPageSize {
+		log.Fatalf("%s can't be greater than %s", defaultPageSizeEnv, maxPageSizeEnv)
+	}
+	server := NewAPIServer(":8080", false, db, maxBodySize, int(defaultPageSize), int(maxPageSize))
 	server.Run()
 }
+
+// positiveEnv returns the positive integer held by the environment variable,
+// or fallback when it isn't set.
+func positiveEnv(name string, fallback int64) int64 {
+	val := os.Getenv(name)
+	if val == "" {
+		return fallback
+	}
+	num, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || num < 1 {
+		log.Fatalf("Invalid %s value %q", name, val)
+	}
+	return num
+}
@@ -5,28 +5,26 @@ import (
 
 	"github.com/jcardenasc93/work-at-olist/app/db"
 	m "github.com/jcardenasc93/work-at-olist/app/middlewares"
+	"github.com/jcardenasc93/work-at-olist/app/models"
 )
 
-func GetAuthors(w http.ResponseWriter, r *http.Request, db db.ApiDB) (*ApiResponse, *ApiError) {
+func GetAuthors(w http.ResponseWriter, r *http.Request, store db.ApiDB) (*ApiResponse, *ApiError) {
 	p, ok := m.CheckPagination(r.Context())
 	if ok == false {
 		return nil, NewApiError(http.StatusInternalServerError, "Internal Error")
 	}
 	params := r.URL.Query()
-	authors, err := db.FetchAuthors(p, params)
+	sort := sortParam(params)
+	authors, page, err := fetchPage(p, sort, func(p *m.PaginationVals) ([]*models.Author, error) {
+		return store.FetchAuthors(p, params)
+	}, func() (int, error) {
+		return store.CountAuthors(params)
+	}, func(author *models.Author) (float64, []any) {
+		return float64(author.Id), db.AuthorCursorKey(sort, author)
+	})
 	if err != nil {
-		return nil, NewApiError(http.StatusInternalServerError, "Couldn't fetch authors from database")
+		return nil, listError(err, http.StatusInternalServerError, "Couldn't fetch authors from database")
 	}
 
-	if len(authors) > 0 {
-		var nextPage int
-		if p.PageId == 0 {
-			nextPage = p.Limit
-		} else {
-			nextPage = p.PageId + p.Limit
-		}
-		return NewApiResponse(200, authors, &nextPage), nil
-	}
-
-	return NewApiResponse(200, authors, nil), nil
+	return NewApiResponse(200, authors, page), nil
 }
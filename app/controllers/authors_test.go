@@ -19,6 +19,8 @@ func getAuthorsAPISuccess(t *testing.T) {
 	populateAuthors()
 	t.Run("Fetch authors with no params", getAuthorsNoParams)
 	t.Run("Fetch authors with params", getAuthorsWithParams)
+	t.Run("Fetch authors optional fields", getAuthorsOptionalFields)
+	t.Run("Fetch authors sorted", getAuthorsSorted)
 }
 
 func getAuthorsNoParams(t *testing.T) {
@@ -39,15 +41,17 @@ func getAuthorsNoParams(t *testing.T) {
 	if len(authors) != len(mockDB.Authors[:middlewares.DefaultLimit]) {
 		t.Errorf("Expected %d authors but got %d", len(mockDB.Authors), len(authors))
 	}
-	if *apiRes.NextPage != middlewares.DefaultLimit {
-		t.Errorf("Expected %d next_page value but got %d", middlewares.DefaultLimit, *apiRes.NextPage)
+	if apiRes.Page == nil || apiRes.NextCursor == nil {
+		t.Error("Expected next_cursor value")
+	} else if apiRes.PrevCursor != nil {
+		t.Errorf("Expected no prev_cursor on first page but got %s", *apiRes.PrevCursor)
 	}
 }
 
 func getAuthorsPaginationErr(t *testing.T) {
 	handler := HTTPHandleFunc(GetAuthors, mockDB)
 	testHandler := middlewares.Pagination(handler)
-	req := httptest.NewRequest(http.MethodGet, "/?page_id=text", nil)
+	req := httptest.NewRequest(http.MethodGet, "/?cursor=text", nil)
 	resRecorder := httptest.NewRecorder()
 	testHandler.ServeHTTP(resRecorder, req)
 	response := resRecorder.Result()
@@ -57,19 +61,36 @@ func getAuthorsPaginationErr(t *testing.T) {
 
 	defer response.Body.Close()
 
-	req = httptest.NewRequest(http.MethodGet, "/?limit=text", nil)
+	for _, query := range []string{"limit=text", "limit=0", "limit=-3", "limit=101", "include=everything"} {
+		req = httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		resRecorder = httptest.NewRecorder()
+		testHandler.ServeHTTP(resRecorder, req)
+		response = resRecorder.Result()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected HTTP code %d for %s but got %d", http.StatusBadRequest, query, response.StatusCode)
+		}
+		if contentType := response.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Expected JSON error for %s but got %s", query, contentType)
+		}
+		apiErr := decodeResponseBody[ApiError](t, response.Body)
+		if apiErr.StatusCode != http.StatusBadRequest || apiErr.Msg == "" {
+			t.Errorf("Expected ApiError for %s but got %+v", query, apiErr)
+		}
+	}
+
+	limited := middlewares.PaginationLimits(5, 10)(handler)
+	req = httptest.NewRequest(http.MethodGet, "/?limit=11", nil)
 	resRecorder = httptest.NewRecorder()
-	testHandler.ServeHTTP(resRecorder, req)
-	response = resRecorder.Result()
-	if response.StatusCode != http.StatusBadRequest {
-		t.Errorf("Expected HTTP code %d but got %d", http.StatusBadRequest, response.StatusCode)
+	limited.ServeHTTP(resRecorder, req)
+	if resRecorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected HTTP code %d over the max limit but got %d", http.StatusBadRequest, resRecorder.Code)
 	}
 }
 
 func getAuthorsWithParams(t *testing.T) {
 	limit := 5
 	resp := getAuthorsWithLimit(t, limit)
-	getAuthorsWithPageId(t, limit, *resp.NextPage)
+	getAuthorsWithCursor(t, limit, *resp.NextCursor)
 	getAuthorsNameFilter(t, "7", limit)
 }
 
@@ -94,16 +115,16 @@ func getAuthorsWithLimit(t *testing.T, limit int) ApiResponse {
 	if len(authors) != limit {
 		t.Errorf("Expected %d authors but got %d", limit, len(authors))
 	}
-	if *apiRes.NextPage != limit {
-		t.Errorf("Expected %d next_page value but got %d", middlewares.DefaultLimit, apiRes.NextPage)
+	if apiRes.Page == nil || apiRes.NextCursor == nil {
+		t.Error("Expected next_cursor value")
 	}
 	return apiRes
 }
 
-func getAuthorsWithPageId(t *testing.T, limit, pageId int) {
+func getAuthorsWithCursor(t *testing.T, limit int, cursor string) {
 	handler := HTTPHandleFunc(GetAuthors, mockDB)
 	testHandler := middlewares.Pagination(handler)
-	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/?limit=%d&page_id=%d", limit, pageId), nil)
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/?limit=%d&cursor=%s", limit, cursor), nil)
 	resRecorder := httptest.NewRecorder()
 	testHandler.ServeHTTP(resRecorder, req)
 	response := resRecorder.Result()
@@ -130,3 +151,58 @@ func getAuthorsNameFilter(t *testing.T, name string, limit int) {
 		t.Errorf("Expected %d authors but got %d", 1, len(authors))
 	}
 }
+
+func getAuthorsOptionalFields(t *testing.T) {
+	birthYear := 1950
+	nationality := "BR"
+	mockDB.Authors[0].BirthYear = &birthYear
+	mockDB.Authors[0].Nationality = &nationality
+	defer populateAuthors()
+
+	handler := HTTPHandleFunc(GetAuthors, mockDB)
+	testHandler := middlewares.Pagination(handler)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	resRecorder := httptest.NewRecorder()
+	testHandler.ServeHTTP(resRecorder, req)
+	response := resRecorder.Result()
+	apiRes := decodeResponseBody[ApiResponse](t, response.Body)
+	authors := apiRes.Data.([]interface{})
+	author := authors[0].(map[string]any)
+	if author["birth_year"] != float64(birthYear) {
+		t.Errorf("Expected %d birth_year but got %v", birthYear, author["birth_year"])
+	}
+	if author["nationality"] != nationality {
+		t.Errorf("Expected %s nationality but got %v", nationality, author["nationality"])
+	}
+	externalId, ok := author["external_id"]
+	if !ok || externalId != nil {
+		t.Errorf("Expected null external_id but got %v", externalId)
+	}
+}
+
+func getAuthorsSorted(t *testing.T) {
+	handler := HTTPHandleFunc(GetAuthors, mockDB)
+	testHandler := middlewares.Pagination(handler)
+	req := httptest.NewRequest(http.MethodGet, "/?sort=-name&limit=2", nil)
+	resRecorder := httptest.NewRecorder()
+	testHandler.ServeHTTP(resRecorder, req)
+	apiRes := decodeResponseBody[ApiResponse](t, resRecorder.Result().Body)
+	names := []any{}
+	for _, author := range apiRes.Data.([]interface{}) {
+		names = append(names, author.(map[string]any)["name"])
+	}
+	// Names are compared as strings, so Author 10 sorts before Author 2
+	expected := []any{"Author 9", "Author 8"}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("Expected authors %v but got %v", expected, names)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/?sort=-name&limit=2&cursor="+*apiRes.NextCursor, nil)
+	resRecorder = httptest.NewRecorder()
+	testHandler.ServeHTTP(resRecorder, req)
+	apiRes = decodeResponseBody[ApiResponse](t, resRecorder.Result().Body)
+	author := apiRes.Data.([]interface{})[0].(map[string]any)
+	if author["name"] != "Author 7" {
+		t.Errorf("Expected Author 7 after the cursor but got %v", author["name"])
+	}
+}
@@ -3,7 +3,12 @@ package controllers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
 
 	"github.com/jcardenasc93/work-at-olist/app/db"
 	mid "github.com/jcardenasc93/work-at-olist/app/middlewares"
@@ -11,14 +16,12 @@ import (
 )
 
 func CreateBook(w http.ResponseWriter, r *http.Request, db db.ApiDB) (*ApiResponse, *ApiError) {
-	bookReq := new(mod.CreateBookReq)
-	err := json.NewDecoder(r.Body).Decode(bookReq)
-	if err != nil {
-		return nil, NewApiError(http.StatusBadRequest, "Invalid request body")
+	bookReq, apiErr := decodeCreateBookReq(r)
+	if apiErr != nil {
+		return nil, apiErr
 	}
-	err = checkEmptyVals(bookReq)
-	if err != nil {
-		return nil, NewApiError(http.StatusBadRequest, err.Error())
+	if apiErr := checkAuthorIds(r, db, bookReq.Authors, true); apiErr != nil {
+		return nil, apiErr
 	}
 	book, err := db.InsertBook(r.Context(), bookReq)
 	if err != nil {
@@ -27,43 +30,250 @@ func CreateBook(w http.ResponseWriter, r *http.Request, db db.ApiDB) (*ApiRespon
 	return NewApiResponse(http.StatusCreated, book, nil), nil
 }
 
-func GetBooks(w http.ResponseWriter, r *http.Request, db db.ApiDB) (*ApiResponse, *ApiError) {
+func GetBooks(w http.ResponseWriter, r *http.Request, store db.ApiDB) (*ApiResponse, *ApiError) {
 	p, ok := mid.CheckPagination(r.Context())
 	if ok == false {
 		return nil, NewApiError(http.StatusInternalServerError, "Internal Error")
 	}
 	params := r.URL.Query()
-	books, err := db.FetchBooks(p, params)
+	sort := sortParam(params)
+	books, page, err := fetchPage(p, sort, func(p *mid.PaginationVals) ([]*mod.Book, error) {
+		return store.FetchBooks(p, params)
+	}, func() (int, error) {
+		return store.CountBooks(params)
+	}, bookKey(sort))
+	if err != nil {
+		return nil, listError(err, http.StatusBadRequest, "Error fetching books")
+	}
+	return NewApiResponse(http.StatusOK, books, page), nil
+}
+
+// GetTrashedBooks lists the soft deleted books.
+func GetTrashedBooks(w http.ResponseWriter, r *http.Request, store db.ApiDB) (*ApiResponse, *ApiError) {
+	p, ok := mid.CheckPagination(r.Context())
+	if ok == false {
+		return nil, NewApiError(http.StatusInternalServerError, "Internal Error")
+	}
+	params := r.URL.Query()
+	sort := sortParam(params)
+	books, page, err := fetchPage(p, sort, func(p *mid.PaginationVals) ([]*mod.Book, error) {
+		return store.FetchTrashedBooks(p, params)
+	}, func() (int, error) {
+		return store.CountTrashedBooks(params)
+	}, bookKey(sort))
+	if err != nil {
+		return nil, listError(err, http.StatusBadRequest, "Error fetching trashed books")
+	}
+	return NewApiResponse(http.StatusOK, books, page), nil
+}
+
+// bookKey returns the cursor key of the books listed in the given sort.
+func bookKey(sort string) func(*mod.Book) (float64, []any) {
+	return func(book *mod.Book) (float64, []any) {
+		return book.Id, db.BookCursorKey(sort, book)
+	}
+}
+
+func GetBook(w http.ResponseWriter, r *http.Request, store db.ApiDB) (*ApiResponse, *ApiError) {
+	id, apiErr := bookIdParam(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	book, err := store.FetchBook(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, NewApiError(http.StatusNotFound, "Book not found")
+	}
 	if err != nil {
-		return nil, NewApiError(http.StatusBadRequest, "Error fetching books")
-	}
-	if len(books) > 0 {
-		var nextPage int
-		if p.PageId == 0 {
-			nextPage = p.Limit
-		} else {
-			nextPage = p.PageId + p.Limit
+		return nil, NewApiError(http.StatusInternalServerError, "Couldn't fetch book from database")
+	}
+	return NewApiResponse(http.StatusOK, book, nil), nil
+}
+
+// ReplaceBook replaces every attribute of a book, including its authors.
+func ReplaceBook(w http.ResponseWriter, r *http.Request, store db.ApiDB) (*ApiResponse, *ApiError) {
+	id, apiErr := bookIdParam(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	bookReq, apiErr := decodeCreateBookReq(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := checkAuthorIds(r, store, bookReq.Authors, true); apiErr != nil {
+		return nil, apiErr
+	}
+	return updateBook(r, store, id, mod.NewReplaceBookReq(bookReq))
+}
+
+// PatchBook updates only the given attributes of a book. Authors can be
+// replaced with authors or changed one by one with add_authors and
+// remove_authors.
+func PatchBook(w http.ResponseWriter, r *http.Request, store db.ApiDB) (*ApiResponse, *ApiError) {
+	id, apiErr := bookIdParam(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	bookReq := new(mod.UpdateBookReq)
+	if apiErr = decodeJSONBody(r, bookReq); apiErr != nil {
+		return nil, apiErr
+	}
+	if errs := bookReq.Validate(); len(errs) > 0 {
+		return nil, NewValidationError(errs)
+	}
+	if bookReq.Authors != nil {
+		if apiErr := checkAuthorIds(r, store, *bookReq.Authors, true); apiErr != nil {
+			return nil, apiErr
 		}
-		return NewApiResponse(200, books, &nextPage), nil
 	}
-	return NewApiResponse(http.StatusOK, books, nil), nil
+	if apiErr := checkAuthorIds(r, store, bookReq.AddAuthors, false); apiErr != nil {
+		return nil, apiErr
+	}
+	return updateBook(r, store, id, bookReq)
 }
 
-func checkEmptyVals(bookReq *mod.CreateBookReq) error {
-	var nameDef string
-	var editionDef float64
-	var pubYearDef float64
-	if bookReq.Name == nameDef {
-		return errors.New("Missing name value")
+func updateBook(r *http.Request, store db.ApiDB, id float64, bookReq *mod.UpdateBookReq) (*ApiResponse, *ApiError) {
+	book, err := store.UpdateBook(r.Context(), id, bookReq)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, NewApiError(http.StatusNotFound, "Book not found")
+	}
+	if errors.Is(err, db.ErrNoAuthors) {
+		return nil, noAuthorsError()
+	}
+	if err != nil {
+		return nil, NewApiError(http.StatusInternalServerError, "Couldn't update book")
 	}
-	if bookReq.Edition == editionDef {
-		return errors.New("Missing edition value")
+	return NewApiResponse(http.StatusOK, book, nil), nil
+}
+
+// DeleteBook moves a book to the trash, or removes it for good when the
+// permanent query param is true.
+func DeleteBook(w http.ResponseWriter, r *http.Request, store db.ApiDB) (*ApiResponse, *ApiError) {
+	id, apiErr := bookIdParam(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	permanent := false
+	if val := r.URL.Query().Get("permanent"); val != "" {
+		var err error
+		if permanent, err = strconv.ParseBool(val); err != nil {
+			return nil, NewApiError(http.StatusBadRequest, "Invalid permanent value")
+		}
+	}
+	var err error
+	if permanent {
+		err = store.DeleteBook(r.Context(), id)
+	} else {
+		err = store.TrashBook(r.Context(), id)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, NewApiError(http.StatusNotFound, "Book not found")
+	}
+	if err != nil {
+		return nil, NewApiError(http.StatusInternalServerError, "Couldn't delete book")
+	}
+	return NewApiResponse(http.StatusNoContent, nil, nil), nil
+}
+
+func RestoreBook(w http.ResponseWriter, r *http.Request, store db.ApiDB) (*ApiResponse, *ApiError) {
+	id, apiErr := bookIdParam(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	book, err := store.RestoreBook(r.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, NewApiError(http.StatusNotFound, "Book not found in trash")
+	}
+	if err != nil {
+		return nil, NewApiError(http.StatusInternalServerError, "Couldn't restore book")
+	}
+	return NewApiResponse(http.StatusOK, book, nil), nil
+}
+
+// bookIdParam returns the book id given in the URL path.
+func bookIdParam(r *http.Request) (float64, *ApiError) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, NewApiError(http.StatusBadRequest, "Invalid book id")
+	}
+	return float64(id), nil
+}
+
+// decodeCreateBookReq decodes and validates the body of the requests carrying
+// a whole book. The body is also decoded as a map to tell the attributes left
+// out apart from the ones set to their zero value.
+func decodeCreateBookReq(r *http.Request) (*mod.CreateBookReq, *ApiError) {
+	body, apiErr := readJSONBody(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	bookReq := new(mod.CreateBookReq)
+	if apiErr = decodeStrict(body, bookReq); apiErr != nil {
+		return nil, apiErr
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, NewApiError(http.StatusBadRequest, "Invalid request body")
+	}
+	present := map[string]bool{}
+	for field, value := range fields {
+		present[field] = string(value) != "null"
+	}
+	if errs := bookReq.Validate(present); len(errs) > 0 {
+		return nil, NewValidationError(errs)
+	}
+	return bookReq, nil
+}
+
+// noAuthorsError returns the 422 error of the requests leaving a book without
+// authors.
+func noAuthorsError() *ApiError {
+	apiErr := NewApiError(http.StatusUnprocessableEntity, "Book must have at least one author")
+	apiErr.Errors = []mod.FieldError{{Field: "authors", Code: mod.CodeRequired, Message: "at least one author is required"}}
+	return apiErr
+}
+
+// checkAuthorIds returns a 422 error listing the author ids that aren't
+// positive integers, are repeated or don't exist in the database.
+func checkAuthorIds(r *http.Request, store db.ApiDB, authors []float64, required bool) *ApiError {
+	if len(authors) == 0 {
+		if required {
+			return noAuthorsError()
+		}
+		return nil
+	}
+	invalid := []float64{}
+	errs := []mod.FieldError{}
+	reject := func(id float64, code string, format string) {
+		invalid = append(invalid, id)
+		errs = append(errs, mod.FieldError{Field: "authors", Code: code, Message: fmt.Sprintf(format, id)})
+	}
+	seen := map[float64]bool{}
+	ids := []float64{}
+	for _, id := range authors {
+		switch {
+		case seen[id]:
+			reject(id, mod.CodeDuplicate, "author %v is repeated")
+		case id != math.Trunc(id):
+			reject(id, mod.CodeNotInteger, "author %v is not an integer")
+		case id < 1:
+			reject(id, mod.CodeOutOfRange, "author %v must be at least 1")
+		default:
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	missing, err := store.FetchMissingAuthorIds(r.Context(), ids)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't check authors")
 	}
-	if bookReq.PubYear == pubYearDef {
-		return errors.New("Missing publication_year value")
+	for _, id := range missing {
+		reject(id, mod.CodeNotFound, "author %v doesn't exist")
 	}
-	if bookReq.Authors == nil {
-		return errors.New("Missing authors value")
+	if len(invalid) > 0 {
+		apiErr := NewApiError(http.StatusUnprocessableEntity, "Invalid authors")
+		apiErr.InvalidAuthors = invalid
+		apiErr.Errors = errs
+		return apiErr
 	}
 	return nil
 }
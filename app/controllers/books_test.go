@@ -7,10 +7,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/jcardenasc93/work-at-olist/app/middlewares"
+	"github.com/jcardenasc93/work-at-olist/app/models"
 )
 
 const contentType = "application/json"
@@ -127,6 +132,16 @@ func TestCreateBookWrongVals(t *testing.T) {
 	if resp.StatusCode != http.StatusBadRequest {
 		t.Errorf("Expected %d status code but got %d", http.StatusBadRequest, resp.StatusCode)
 	}
+	apiErr := decodeResponseBody[ApiError](t, resp.Body)
+	resp.Body.Close()
+	got := map[string]string{}
+	for _, fieldErr := range apiErr.Errors {
+		got[fieldErr.Field] = fieldErr.Code
+	}
+	expected := map[string]string{"name": "invalid_type", "edition": "invalid_type", "publication_year": "invalid_type", "authors": "invalid_type"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v errors but got %v", expected, got)
+	}
 }
 
 func TestGetBookAPI(t *testing.T) {
@@ -160,18 +175,19 @@ func getBookAPISuccessNoParams(t *testing.T) {
 	if len(books) != expectedLen {
 		t.Errorf("Expected %d books but got %d", expectedLen, len(books))
 	}
-	if *apiRes.NextPage != middlewares.DefaultLimit {
-		t.Errorf("Expected %d next_page value but got %d", middlewares.DefaultLimit, *apiRes.NextPage)
+	if apiRes.Page == nil || apiRes.NextCursor == nil {
+		t.Error("Expected next_cursor value")
 	}
 }
 
 func getBookAPIWithParams(t *testing.T) {
 	limit := 3
 	resp := getBooksWithLimit(t, limit)
-	getBooksWithPageId(t, limit, *resp.NextPage)
+	getBooksWithCursor(t, limit, *resp.NextCursor)
 	getBooksNameFilter(t, "7", limit)
 	year := mockDB.Books[0].PubYear
 	getBooksPubYearFilter(t, year, limit)
+	getBooksCombinedFilters(t)
 }
 
 func getBooksWithLimit(t *testing.T, limit int) ApiResponse {
@@ -195,16 +211,16 @@ func getBooksWithLimit(t *testing.T, limit int) ApiResponse {
 	if len(books) != limit {
 		t.Errorf("Expected %d books but got %d", limit, len(books))
 	}
-	if *apiRes.NextPage != limit {
-		t.Errorf("Expected %d next_page value but got %d", middlewares.DefaultLimit, apiRes.NextPage)
+	if apiRes.Page == nil || apiRes.NextCursor == nil {
+		t.Error("Expected next_cursor value")
 	}
 	return apiRes
 }
 
-func getBooksWithPageId(t *testing.T, limit int, pageId int) {
+func getBooksWithCursor(t *testing.T, limit int, cursor string) {
 	handler := HTTPHandleFunc(GetBooks, mockDB)
 	testHandler := middlewares.Pagination(handler)
-	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/?limit=%d&page_id=%d", limit, pageId), nil)
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/?limit=%d&cursor=%s", limit, cursor), nil)
 	resRecorder := httptest.NewRecorder()
 	testHandler.ServeHTTP(resRecorder, req)
 	response := resRecorder.Result()
@@ -245,3 +261,801 @@ func getBooksPubYearFilter(t *testing.T, year float64, limit int) {
 		t.Errorf("Expected at least one book")
 	}
 }
+
+func getBooksCombinedFilters(t *testing.T) {
+	book := mockDB.Books[len(mockDB.Books)-1]
+	query := fmt.Sprintf("/?limit=%d&publication_year=%v&edition=%v&author=%v",
+		len(mockDB.Books), book.PubYear, book.Edition, book.Authors[0])
+	handler := middlewares.Pagination(HTTPHandleFunc(GetBooks, mockDB))
+	req := httptest.NewRequest(http.MethodGet, query, nil)
+	resRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(resRecorder, req)
+	apiRes := decodeResponseBody[ApiResponse](t, resRecorder.Result().Body)
+	books := apiRes.Data.([]any)
+	if len(books) == 0 {
+		t.Errorf("Expected at least one book for %s", query)
+	}
+	for _, b := range books {
+		data := b.(map[string]any)
+		if data["publication_year"] != book.PubYear || data["edition"] != book.Edition {
+			t.Errorf("Book %v doesn't match filters %s", data, query)
+		}
+		found := false
+		for _, author := range data["authors"].([]any) {
+			found = found || author == book.Authors[0]
+		}
+		if !found {
+			t.Errorf("Book %v doesn't have author %v", data, book.Authors[0])
+		}
+	}
+}
+
+func TestGetBookByIdAPI(t *testing.T) {
+	populateAuthors()
+	populateBooks()
+	t.Run("Success cases", getBookByIdSuccess)
+	t.Run("Failing cases", getBookByIdErr)
+}
+
+func bookRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/books/{id}", HTTPHandleFunc(GetBook, mockDB))
+	r.Put("/books/{id}", HTTPHandleFunc(ReplaceBook, mockDB))
+	r.Patch("/books/{id}", HTTPHandleFunc(PatchBook, mockDB))
+	r.Delete("/books/{id}", HTTPHandleFunc(DeleteBook, mockDB))
+	r.Post("/books/{id}/restore", HTTPHandleFunc(RestoreBook, mockDB))
+	r.With(middlewares.Pagination).Get("/books/trash", HTTPHandleFunc(GetTrashedBooks, mockDB))
+	return r
+}
+
+func getBookByIdSuccess(t *testing.T) {
+	expected := mockDB.Books[4]
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/books/%v", expected.Id), nil)
+	resRecorder := httptest.NewRecorder()
+	bookRouter().ServeHTTP(resRecorder, req)
+	response := resRecorder.Result()
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected HTTP code %d but got %d", http.StatusOK, response.StatusCode)
+	}
+	apiRes := decodeResponseBody[ApiResponse](t, response.Body)
+	book := apiRes.Data.(map[string]any)
+	if book["id"] != expected.Id {
+		t.Errorf("Expected %v book's id but got %v", expected.Id, book["id"])
+	}
+	if book["name"] != expected.Name {
+		t.Errorf("Expected %s book's name but got %v", expected.Name, book["name"])
+	}
+	if len(book["authors"].([]any)) != len(expected.Authors) {
+		t.Errorf("Expected %d authors but got %v", len(expected.Authors), book["authors"])
+	}
+}
+
+func getBookByIdErr(t *testing.T) {
+	cases := map[string]int{
+		"/books/9999": http.StatusNotFound,
+		"/books/text": http.StatusBadRequest,
+		"/books/0":    http.StatusBadRequest,
+	}
+	for url, status := range cases {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		resRecorder := httptest.NewRecorder()
+		bookRouter().ServeHTTP(resRecorder, req)
+		response := resRecorder.Result()
+		if response.StatusCode != status {
+			t.Errorf("Expected HTTP code %d for %s but got %d", status, url, response.StatusCode)
+		}
+		apiErr := decodeResponseBody[ApiError](t, response.Body)
+		if apiErr.StatusCode != status {
+			t.Errorf("Expected %d status_code in body but got %d", status, apiErr.StatusCode)
+		}
+		response.Body.Close()
+	}
+}
+
+func TestUpdateBookAPI(t *testing.T) {
+	populateAuthors()
+	populateBooks()
+	t.Run("Replace book", replaceBookSuccess)
+	t.Run("Patch book", patchBookSuccess)
+	t.Run("Remove last author", patchBookLastAuthor)
+	t.Run("Failing cases", updateBookErr)
+}
+
+func sendBookUpdate(t *testing.T, method string, url string, body map[string]any) *http.Response {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	req := httptest.NewRequest(method, url, bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", contentType)
+	resRecorder := httptest.NewRecorder()
+	bookRouter().ServeHTTP(resRecorder, req)
+	return resRecorder.Result()
+}
+
+func replaceBookSuccess(t *testing.T) {
+	book := mockDB.Books[0]
+	body := map[string]any{
+		"name":             "Replaced book",
+		"edition":          float64(5),
+		"publication_year": float64(2010),
+		"authors":          []float64{2},
+	}
+	response := sendBookUpdate(t, http.MethodPut, fmt.Sprintf("/books/%v", book.Id), body)
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected HTTP code %d but got %d", http.StatusOK, response.StatusCode)
+	}
+	apiRes := decodeResponseBody[ApiResponse](t, response.Body)
+	data := apiRes.Data.(map[string]any)
+	for _, k := range []string{"name", "edition", "publication_year"} {
+		checkRespBody(t, body, data, k)
+	}
+	if !reflect.DeepEqual(mockDB.AuthorsBooks[book.Id], body["authors"]) {
+		t.Errorf("Expected %v authors but got %v", body["authors"], mockDB.AuthorsBooks[book.Id])
+	}
+}
+
+func patchBookSuccess(t *testing.T) {
+	book := mockDB.Books[1]
+	book.Authors = []float64{1}
+	edition := book.Edition
+	body := map[string]any{
+		"name":           "Patched book",
+		"add_authors":    []float64{2},
+		"remove_authors": []float64{1},
+	}
+	response := sendBookUpdate(t, http.MethodPatch, fmt.Sprintf("/books/%v", book.Id), body)
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected HTTP code %d but got %d", http.StatusOK, response.StatusCode)
+	}
+	apiRes := decodeResponseBody[ApiResponse](t, response.Body)
+	data := apiRes.Data.(map[string]any)
+	checkRespBody(t, body, data, "name")
+	if data["edition"] != edition {
+		t.Errorf("Expected edition %v to be kept but got %v", edition, data["edition"])
+	}
+	if !reflect.DeepEqual(data["authors"], []any{float64(2)}) {
+		t.Errorf("Expected [2] authors but got %v", data["authors"])
+	}
+}
+
+func patchBookLastAuthor(t *testing.T) {
+	book := mockDB.Books[2]
+	book.Authors = []float64{1}
+	for _, body := range []map[string]any{
+		{"name": "Orphan book", "remove_authors": []float64{1}},
+		{"add_authors": []float64{2}, "remove_authors": []float64{1, 2}},
+	} {
+		response := sendBookUpdate(t, http.MethodPatch, fmt.Sprintf("/books/%v", book.Id), body)
+		if response.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("Expected HTTP code %d for %v but got %d", http.StatusUnprocessableEntity, body, response.StatusCode)
+		}
+		apiErr := decodeResponseBody[ApiError](t, response.Body)
+		response.Body.Close()
+		if len(apiErr.Errors) != 1 || apiErr.Errors[0].Field != "authors" || apiErr.Errors[0].Code != models.CodeRequired {
+			t.Errorf("Expected a required authors error for %v but got %v", body, apiErr.Errors)
+		}
+	}
+	if book.Name == "Orphan book" || !reflect.DeepEqual(book.Authors, []float64{1}) {
+		t.Errorf("Expected the book to be left untouched but got %+v", book)
+	}
+}
+
+func updateBookErr(t *testing.T) {
+	valid := map[string]any{
+		"name":             "Book",
+		"edition":          float64(1),
+		"publication_year": float64(2000),
+		"authors":          []float64{1},
+	}
+	cases := []struct {
+		method string
+		url    string
+		body   map[string]any
+		status int
+	}{
+		{http.MethodPut, "/books/9999", valid, http.StatusNotFound},
+		{http.MethodPut, "/books/text", valid, http.StatusBadRequest},
+		{http.MethodPut, "/books/1", map[string]any{"name": "Book"}, http.StatusBadRequest},
+		{http.MethodPatch, "/books/9999", map[string]any{"name": "Book"}, http.StatusNotFound},
+		{http.MethodPatch, "/books/1", map[string]any{}, http.StatusBadRequest},
+		{http.MethodPatch, "/books/1", map[string]any{"name": ""}, http.StatusBadRequest},
+		{http.MethodPatch, "/books/1", map[string]any{"edition": 0}, http.StatusBadRequest},
+		{http.MethodPatch, "/books/1", map[string]any{"authors": []float64{1}, "add_authors": []float64{2}}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		response := sendBookUpdate(t, tc.method, tc.url, tc.body)
+		if response.StatusCode != tc.status {
+			t.Errorf("Expected HTTP code %d for %s %s %v but got %d", tc.status, tc.method, tc.url, tc.body, response.StatusCode)
+		}
+		response.Body.Close()
+	}
+}
+
+func TestUpdateBookWrongTypes(t *testing.T) {
+	populateBooks()
+	cases := []struct {
+		method   string
+		body     map[string]any
+		expected map[string]string
+	}{
+		{
+			http.MethodPut,
+			map[string]any{"name": "Book", "edition": "1", "publication_year": 2000, "authors": []string{"1"}},
+			map[string]string{"edition": "invalid_type", "authors": "invalid_type"},
+		},
+		{
+			http.MethodPatch,
+			map[string]any{"name": true, "add_authors": 2},
+			map[string]string{"name": "invalid_type", "add_authors": "invalid_type"},
+		},
+	}
+	for _, tc := range cases {
+		response := sendBookUpdate(t, tc.method, "/books/1", tc.body)
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected HTTP code %d for %s %v but got %d", http.StatusBadRequest, tc.method, tc.body, response.StatusCode)
+		}
+		apiErr := decodeResponseBody[ApiError](t, response.Body)
+		response.Body.Close()
+		got := map[string]string{}
+		for _, fieldErr := range apiErr.Errors {
+			got[fieldErr.Field] = fieldErr.Code
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Expected %v errors for %s but got %v", tc.expected, tc.method, got)
+		}
+	}
+}
+
+func TestDeleteBookAPI(t *testing.T) {
+	populateAuthors()
+	populateBooks()
+	book := mockDB.Books[2]
+	url := fmt.Sprintf("/books/%v?permanent=true", book.Id)
+
+	req := httptest.NewRequest(http.MethodDelete, url, nil)
+	resRecorder := httptest.NewRecorder()
+	bookRouter().ServeHTTP(resRecorder, req)
+	response := resRecorder.Result()
+	if response.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected HTTP code %d but got %d", http.StatusNoContent, response.StatusCode)
+	}
+	if resRecorder.Body.Len() != 0 {
+		t.Errorf("Expected empty body but got %s", resRecorder.Body.String())
+	}
+	if _, ok := mockDB.AuthorsBooks[book.Id]; ok {
+		t.Error("Expected author_book relationships to be deleted")
+	}
+
+	cases := map[string]int{
+		url:                       http.StatusNotFound,
+		"/books/text":             http.StatusBadRequest,
+		"/books/1?permanent=text": http.StatusBadRequest,
+	}
+	for url, status := range cases {
+		req := httptest.NewRequest(http.MethodDelete, url, nil)
+		resRecorder := httptest.NewRecorder()
+		bookRouter().ServeHTTP(resRecorder, req)
+		if resRecorder.Code != status {
+			t.Errorf("Expected HTTP code %d for %s but got %d", status, url, resRecorder.Code)
+		}
+	}
+}
+
+func TestTrashBookAPI(t *testing.T) {
+	populateAuthors()
+	populateBooks()
+	book := mockDB.Books[3]
+	url := fmt.Sprintf("/books/%v", book.Id)
+
+	if code := serveBookRequest(http.MethodDelete, url).Code; code != http.StatusNoContent {
+		t.Errorf("Expected HTTP code %d trashing book but got %d", http.StatusNoContent, code)
+	}
+	if code := serveBookRequest(http.MethodGet, url).Code; code != http.StatusNotFound {
+		t.Errorf("Expected HTTP code %d for trashed book but got %d", http.StatusNotFound, code)
+	}
+	if code := serveBookRequest(http.MethodDelete, url).Code; code != http.StatusNotFound {
+		t.Errorf("Expected HTTP code %d trashing book twice but got %d", http.StatusNotFound, code)
+	}
+
+	books, _ := mockDB.FetchBooks(&middlewares.PaginationVals{Limit: len(mockDB.Books)}, nil)
+	for _, b := range books {
+		if b.Id == book.Id {
+			t.Error("Expected trashed book to be hidden from books list")
+		}
+	}
+
+	res := serveBookRequest(http.MethodGet, "/books/trash")
+	apiRes := decodeResponseBody[ApiResponse](t, res.Result().Body)
+	trashed := apiRes.Data.([]any)
+	if len(trashed) != 1 || trashed[0].(map[string]any)["id"] != book.Id {
+		t.Errorf("Expected book %v in trash but got %v", book.Id, trashed)
+	}
+	if trashed[0].(map[string]any)["deleted_at"] == nil {
+		t.Error("Expected deleted_at in trashed book")
+	}
+
+	res = serveBookRequest(http.MethodPost, url+"/restore")
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected HTTP code %d restoring book but got %d", http.StatusOK, res.Code)
+	}
+	if code := serveBookRequest(http.MethodGet, url).Code; code != http.StatusOK {
+		t.Errorf("Expected HTTP code %d for restored book but got %d", http.StatusOK, code)
+	}
+	if code := serveBookRequest(http.MethodPost, url+"/restore").Code; code != http.StatusNotFound {
+		t.Errorf("Expected HTTP code %d restoring book not in trash but got %d", http.StatusNotFound, code)
+	}
+}
+
+func serveBookRequest(method string, url string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	resRecorder := httptest.NewRecorder()
+	bookRouter().ServeHTTP(resRecorder, req)
+	return resRecorder
+}
+
+func TestCreateBookInvalidAuthors(t *testing.T) {
+	populateAuthors()
+	server := httptest.NewServer(HTTPHandleFunc(CreateBook, mockDB))
+	cases := []struct {
+		authors []float64
+		invalid []float64
+		codes   []string
+	}{
+		{[]float64{}, nil, []string{"required"}},
+		{[]float64{1, 1}, []float64{1}, []string{"duplicate"}},
+		{[]float64{-2, 1.5, 2}, []float64{-2, 1.5}, []string{"out_of_range", "not_integer"}},
+		{[]float64{1, 9999}, []float64{9999}, []string{"not_found"}},
+	}
+	for _, tc := range cases {
+		body := map[string]any{
+			"name":             "Testing book",
+			"edition":          float64(1),
+			"publication_year": float64(2002),
+			"authors":          tc.authors,
+		}
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		resp, err := http.Post(server.URL, contentType, bytes.NewBuffer(jsonBody))
+		if err != nil {
+			t.Fatalf("Couldn't make request: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("Expected %d status code for %v but got %d", http.StatusUnprocessableEntity, tc.authors, resp.StatusCode)
+		}
+		apiErr := decodeResponseBody[ApiError](t, resp.Body)
+		if !reflect.DeepEqual(apiErr.InvalidAuthors, tc.invalid) {
+			t.Errorf("Expected %v invalid authors but got %v", tc.invalid, apiErr.InvalidAuthors)
+		}
+		codes := []string{}
+		for _, fieldErr := range apiErr.Errors {
+			codes = append(codes, fieldErr.Code)
+		}
+		if !reflect.DeepEqual(codes, tc.codes) {
+			t.Errorf("Expected %v error codes for %v but got %v", tc.codes, tc.authors, codes)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestCreateBookValidationErrors(t *testing.T) {
+	populateAuthors()
+	server := httptest.NewServer(HTTPHandleFunc(CreateBook, mockDB))
+	cases := []struct {
+		body     map[string]any
+		expected map[string]string
+	}{
+		{
+			map[string]any{},
+			map[string]string{"name": "required", "edition": "required", "publication_year": "required", "authors": "required"},
+		},
+		{
+			map[string]any{"name": strings.Repeat("a", 81), "edition": 0, "publication_year": 0, "authors": []float64{1}},
+			map[string]string{"name": "too_long", "edition": "out_of_range", "publication_year": "out_of_range"},
+		},
+		{
+			map[string]any{"name": "Book", "edition": -1, "publication_year": time.Now().Year() + 1, "authors": []float64{1}},
+			map[string]string{"edition": "out_of_range", "publication_year": "out_of_range"},
+		},
+		{
+			map[string]any{"name": " ", "edition": 1.5, "publication_year": 2000, "authors": []float64{1}},
+			map[string]string{"name": "required", "edition": "not_integer"},
+		},
+	}
+	for _, tc := range cases {
+		jsonBody, err := json.Marshal(tc.body)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		resp, err := http.Post(server.URL, contentType, bytes.NewBuffer(jsonBody))
+		if err != nil {
+			t.Fatalf("Couldn't make request: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected %d status code for %v but got %d", http.StatusBadRequest, tc.body, resp.StatusCode)
+		}
+		apiErr := decodeResponseBody[ApiError](t, resp.Body)
+		got := map[string]string{}
+		for _, fieldErr := range apiErr.Errors {
+			got[fieldErr.Field] = fieldErr.Code
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Expected %v errors but got %v", tc.expected, got)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestCreateBookStrictDecoding(t *testing.T) {
+	populateAuthors()
+	handler := middlewares.MaxBodySize(256)(HTTPHandleFunc(CreateBook, mockDB))
+	valid := `{"name": "Book", "edition": 1, "publication_year": 2000, "authors": [1]}`
+	cases := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{contentType, valid, http.StatusCreated},
+		{"application/json; charset=utf-8", valid, http.StatusCreated},
+		{contentType, `{"name": "Book", "edition": 1, "publicationYear": 2000, "authors": [1]}`, http.StatusBadRequest},
+		{contentType, valid + `{"name": "Other"}`, http.StatusBadRequest},
+		{contentType, valid + ` []`, http.StatusBadRequest},
+		{"text/plain", valid, http.StatusUnsupportedMediaType},
+		{"", valid, http.StatusUnsupportedMediaType},
+		{contentType, `{"name": "` + strings.Repeat("a", 300) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != tc.status {
+			t.Errorf("Expected %d status code for %q %s but got %d", tc.status, tc.contentType, tc.body, resRecorder.Code)
+		}
+	}
+}
+
+func TestGetBooksFilterGrammar(t *testing.T) {
+	populateAuthors()
+	mockDB.SetBooks([]*models.Book{
+		models.NewBook(1, "Go in Action", 1, 1985, []float64{1}),
+		models.NewBook(2, "Learning Go", 2, 1990, []float64{1, 42}),
+		models.NewBook(3, "Fluent Python", 3, 1995, []float64{2}),
+		models.NewBook(4, "Python Cookbook", 4, 2000, []float64{42}),
+	})
+	handler := middlewares.Pagination(HTTPHandleFunc(GetBooks, mockDB))
+	cases := map[string][]float64{
+		"publication_year[gte]=1990&publication_year[lt]=2000": {2, 3},
+		"edition=1,3":                         {1, 3},
+		"edition[nin]=1,3":                    {2, 4},
+		"author!=42":                          {1, 3},
+		"author=1,2":                          {1, 2, 3},
+		"name=python&edition[gt]=3":           {4},
+		"name!=go":                            {3, 4},
+		"publication_year[ne]=1985&author=42": {2, 4},
+	}
+	for query, expected := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/?limit=10&"+query, nil)
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != http.StatusOK {
+			t.Errorf("Expected HTTP code %d for %s but got %d", http.StatusOK, query, resRecorder.Code)
+			continue
+		}
+		apiRes := decodeResponseBody[ApiResponse](t, resRecorder.Result().Body)
+		ids := []float64{}
+		for _, book := range apiRes.Data.([]any) {
+			ids = append(ids, book.(map[string]any)["id"].(float64))
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("Expected books %v for %s but got %v", expected, query, ids)
+		}
+	}
+
+	for _, query := range []string{"edition=first", "author[gte]=2", "name[gt]=a", "publication_year[lt]=1,2"} {
+		req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected HTTP code %d for %s but got %d", http.StatusBadRequest, query, resRecorder.Code)
+		}
+	}
+}
+
+func TestGetBooksAuthorNameFilter(t *testing.T) {
+	mockDB.SetAuthors([]*models.Author{
+		models.NewAuthor(1, "Luciano Ramalho"),
+		models.NewAuthor(2, "David Beazley"),
+		models.NewAuthor(3, "Brian K. Jones"),
+	})
+	mockDB.SetBooks([]*models.Book{
+		models.NewBook(1, "Fluent Python", 2, 2022, []float64{1}),
+		models.NewBook(2, "Python Cookbook", 3, 2013, []float64{2, 3}),
+		models.NewBook(3, "Python Essential Reference", 4, 2009, []float64{2}),
+	})
+	handler := middlewares.Pagination(HTTPHandleFunc(GetBooks, mockDB))
+	cases := map[string][]float64{
+		"author_name=RAMALHO":                {1},
+		"author_name=jones":                  {2},
+		"author_name=beazley":                {2, 3},
+		"author_name=beazley&edition[gte]=4": {3},
+		"author_name=beazley&limit=1":        {2},
+		"author_name!=beazley":               {1},
+		"author_name=tolkien":                {},
+	}
+	for query, expected := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		apiRes := decodeResponseBody[ApiResponse](t, resRecorder.Result().Body)
+		ids := []float64{}
+		for _, book := range apiRes.Data.([]any) {
+			ids = append(ids, book.(map[string]any)["id"].(float64))
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("Expected books %v for %s but got %v", expected, query, ids)
+		}
+	}
+}
+
+func TestGetBooksCursorPagination(t *testing.T) {
+	populateAuthors()
+	books := []*models.Book{}
+	// Ids with gaps and a filter skipping some rows, which broke page_id + limit
+	for _, id := range []float64{1, 2, 5, 6, 9, 12, 13, 20} {
+		edition := float64(1)
+		if id == 6 || id == 13 {
+			edition = 2
+		}
+		books = append(books, models.NewBook(id, fmt.Sprintf("Book %v", id), edition, 2000, []float64{1}))
+	}
+	mockDB.SetBooks(books)
+	handler := middlewares.Pagination(HTTPHandleFunc(GetBooks, mockDB))
+	getPage := func(query string) ApiResponse {
+		req := httptest.NewRequest(http.MethodGet, "/?edition=1&limit=2"+query, nil)
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != http.StatusOK {
+			t.Fatalf("Expected HTTP code %d for %s but got %d", http.StatusOK, query, resRecorder.Code)
+		}
+		return decodeResponseBody[ApiResponse](t, resRecorder.Result().Body)
+	}
+	pageIds := func(res ApiResponse) []float64 {
+		ids := []float64{}
+		for _, book := range res.Data.([]any) {
+			ids = append(ids, book.(map[string]any)["id"].(float64))
+		}
+		return ids
+	}
+
+	expected := [][]float64{{1, 2}, {5, 9}, {12, 20}}
+	pages := []ApiResponse{getPage("")}
+	for i := 1; i < len(expected); i++ {
+		pages = append(pages, getPage("&cursor="+*pages[i-1].NextCursor))
+	}
+	for i, page := range pages {
+		if ids := pageIds(page); !reflect.DeepEqual(ids, expected[i]) {
+			t.Errorf("Expected books %v in page %d but got %v", expected[i], i, ids)
+		}
+		if (page.PrevCursor == nil) != (i == 0) {
+			t.Errorf("Unexpected prev_cursor %v in page %d", page.PrevCursor, i)
+		}
+	}
+	if last := pages[len(pages)-1]; last.Page != nil && last.NextCursor != nil {
+		t.Errorf("Expected no next_cursor on the final page but got %s", *last.NextCursor)
+	}
+
+	prev := getPage("&cursor=" + *pages[2].PrevCursor)
+	if ids := pageIds(prev); !reflect.DeepEqual(ids, expected[1]) {
+		t.Errorf("Expected books %v going back but got %v", expected[1], ids)
+	}
+	first := getPage("&cursor=" + *prev.PrevCursor)
+	if ids := pageIds(first); !reflect.DeepEqual(ids, expected[0]) {
+		t.Errorf("Expected books %v going back but got %v", expected[0], ids)
+	}
+	if first.PrevCursor != nil {
+		t.Errorf("Expected no prev_cursor on the first page but got %s", *first.PrevCursor)
+	}
+
+	// A cursor can't be used with other filters
+	req := httptest.NewRequest(http.MethodGet, "/?edition=2&cursor="+*pages[0].NextCursor, nil)
+	resRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(resRecorder, req)
+	if resRecorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected HTTP code %d for cursor with other filters but got %d", http.StatusBadRequest, resRecorder.Code)
+	}
+
+	// Cursor keys only hold strings and numbers
+	cursor, err := middlewares.DecodeCursor(*pages[0].NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][]any{{map[string]any{"a": 1}}, {[]any{1}}, {true}, {nil}} {
+		cursor.Key = key
+		req := httptest.NewRequest(http.MethodGet, "/?edition=1&cursor="+middlewares.EncodeCursor(cursor), nil)
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected HTTP code %d for cursor key %v but got %d", http.StatusBadRequest, key, resRecorder.Code)
+		}
+	}
+}
+
+func TestGetBooksSorted(t *testing.T) {
+	populateAuthors()
+	books := []*models.Book{
+		models.NewBook(1, "Dune", 1, 1965, []float64{1}),
+		models.NewBook(2, "Ubik", 1, 1969, []float64{1}),
+		models.NewBook(3, "Solaris", 2, 1961, []float64{1}),
+		models.NewBook(4, "Neuromancer", 1, 1984, []float64{1}),
+		models.NewBook(5, "Hyperion", 1, 1989, []float64{1}),
+		models.NewBook(6, "Emma", 3, 1965, []float64{1}),
+		models.NewBook(7, "Dune", 2, 1965, []float64{1}),
+	}
+	mockDB.SetBooks(books)
+	handler := middlewares.Pagination(HTTPHandleFunc(GetBooks, mockDB))
+	getPage := func(query string) ApiResponse {
+		req := httptest.NewRequest(http.MethodGet, "/?sort=-publication_year,name&limit=3"+query, nil)
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != http.StatusOK {
+			t.Fatalf("Expected HTTP code %d for %s but got %d", http.StatusOK, query, resRecorder.Code)
+		}
+		return decodeResponseBody[ApiResponse](t, resRecorder.Result().Body)
+	}
+	pageIds := func(res ApiResponse) []float64 {
+		ids := []float64{}
+		for _, book := range res.Data.([]any) {
+			ids = append(ids, book.(map[string]any)["id"].(float64))
+		}
+		return ids
+	}
+
+	// Both Dune books tie on the sort fields and are ordered by id, across
+	// the page boundary
+	expected := [][]float64{{5, 4, 2}, {1, 7, 6}, {3}}
+	pages := []ApiResponse{getPage("")}
+	for i := 1; i < len(expected); i++ {
+		pages = append(pages, getPage("&cursor="+*pages[i-1].NextCursor))
+	}
+	for i, page := range pages {
+		if ids := pageIds(page); !reflect.DeepEqual(ids, expected[i]) {
+			t.Errorf("Expected books %v in page %d but got %v", expected[i], i, ids)
+		}
+	}
+	prev := getPage("&cursor=" + *pages[2].PrevCursor)
+	if ids := pageIds(prev); !reflect.DeepEqual(ids, expected[1]) {
+		t.Errorf("Expected books %v going back but got %v", expected[1], ids)
+	}
+
+	cases := map[string]int{
+		"/?sort=rating":                    http.StatusBadRequest,
+		"/?sort=name,-name":                http.StatusBadRequest,
+		"/?sort=-edition,-id":              http.StatusOK,
+		"/?cursor=" + *pages[0].NextCursor: http.StatusBadRequest,
+	}
+	for url, status := range cases {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != status {
+			t.Errorf("Expected HTTP code %d for %s but got %d", status, url, resRecorder.Code)
+		}
+	}
+}
+
+func TestGetBooksIncludeTotal(t *testing.T) {
+	populateAuthors()
+	books := []*models.Book{}
+	for id := float64(1); id <= 7; id++ {
+		edition := float64(1)
+		if id > 5 {
+			edition = 2
+		}
+		books = append(books, models.NewBook(id, fmt.Sprintf("Book %v", id), edition, 2000, []float64{1}))
+	}
+	mockDB.SetBooks(books)
+	handler := middlewares.PaginationLimits(2, 10)(HTTPHandleFunc(GetBooks, mockDB))
+	cases := []struct {
+		query string
+		total int
+		pages int
+	}{
+		{"/?include=total", 7, 4},
+		{"/?include=total&limit=7", 7, 1},
+		{"/?include=total&edition=1&limit=3", 5, 2},
+		{"/?include=total&edition=3", 0, 0},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.query, nil)
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		apiRes := decodeResponseBody[ApiResponse](t, resRecorder.Result().Body)
+		if apiRes.Page == nil || apiRes.TotalCount == nil || apiRes.PageCount == nil {
+			t.Errorf("Expected total_count and page_count for %s", c.query)
+			continue
+		}
+		if *apiRes.TotalCount != c.total || *apiRes.PageCount != c.pages {
+			t.Errorf("Expected %d books in %d pages for %s but got %d in %d", c.total, c.pages, c.query, *apiRes.TotalCount, *apiRes.PageCount)
+		}
+	}
+
+	// The counts are left out unless asked for, and asking for them doesn't
+	// invalidate the cursors
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	resRecorder := httptest.NewRecorder()
+	handler.ServeHTTP(resRecorder, req)
+	apiRes := decodeResponseBody[ApiResponse](t, resRecorder.Result().Body)
+	if apiRes.TotalCount != nil {
+		t.Errorf("Expected no total_count but got %d", *apiRes.TotalCount)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/?include=total&cursor="+*apiRes.NextCursor, nil)
+	resRecorder = httptest.NewRecorder()
+	handler.ServeHTTP(resRecorder, req)
+	if resRecorder.Code != http.StatusOK {
+		t.Errorf("Expected HTTP code %d but got %d", http.StatusOK, resRecorder.Code)
+	}
+}
+
+func TestGetBooksLinks(t *testing.T) {
+	populateAuthors()
+	books := []*models.Book{}
+	for id := float64(1); id <= 9; id++ {
+		books = append(books, models.NewBook(id, fmt.Sprintf("Book %v", id), float64(int(id)%2+1), 2000, []float64{1}))
+	}
+	mockDB.SetBooks(books)
+	handler := middlewares.Pagination(HTTPHandleFunc(GetBooks, mockDB))
+	linkRe := regexp.MustCompile(`<([^>]*)>; rel="(\w+)"`)
+	getPage := func(url string) (ApiResponse, map[string]string) {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		resRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(resRecorder, req)
+		if resRecorder.Code != http.StatusOK {
+			t.Fatalf("Expected HTTP code %d for %s but got %d", http.StatusOK, url, resRecorder.Code)
+		}
+		links := map[string]string{}
+		for _, match := range linkRe.FindAllStringSubmatch(resRecorder.Header().Get("Link"), -1) {
+			links[match[2]] = match[1]
+		}
+		return decodeResponseBody[ApiResponse](t, resRecorder.Result().Body), links
+	}
+
+	// Crawl the odd books by name descending following only the Link header
+	first := "/books?edition=2&sort=-name&limit=2"
+	ids := []any{}
+	url := first
+	for pages := 0; url != ""; pages++ {
+		if pages > 5 {
+			t.Fatal("Expected the next links to end")
+		}
+		apiRes, links := getPage(url)
+		for _, book := range apiRes.Data.([]any) {
+			ids = append(ids, book.(map[string]any)["id"])
+		}
+		if apiRes.Links == nil || apiRes.Links.Next != links["next"] || apiRes.Links.Prev != links["prev"] || apiRes.Links.First != links["first"] {
+			t.Errorf("Expected the links of the body to match the Link header %v but got %+v", links, apiRes.Links)
+		}
+		if (links["prev"] == "") != (pages == 0) {
+			t.Errorf("Unexpected prev link %q in page %d", links["prev"], pages)
+		}
+		if links["first"] != "/books?edition=2&limit=2&sort=-name" {
+			t.Errorf("Expected the first link to keep the query params but the cursor, got %s", links["first"])
+		}
+		url = links["next"]
+	}
+	expected := []any{float64(9), float64(7), float64(5), float64(3), float64(1)}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected books %v following the links but got %v", expected, ids)
+	}
+}
@@ -1,44 +1,202 @@
 package controllers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/jcardenasc93/work-at-olist/app/db"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+	"github.com/jcardenasc93/work-at-olist/app/render"
 )
 
 type apiFunc func(http.ResponseWriter, *http.Request, db.ApiDB) (*ApiResponse, *ApiError)
 
-type ApiError struct {
-	StatusCode int    `json:"status_code"`
-	Msg        string `json:"message"`
-}
+// ApiError is the error envelope shared with the middlewares.
+type ApiError = render.ApiError
 
 func NewApiError(statusCode int, msg string) *ApiError {
-	return &ApiError{
-		StatusCode: statusCode,
-		Msg:        msg,
+	return render.NewApiError(statusCode, msg)
+}
+
+// NewValidationError returns a 400 error carrying every invalid attribute.
+func NewValidationError(errs []models.FieldError) *ApiError {
+	apiErr := NewApiError(http.StatusBadRequest, "Invalid request values")
+	apiErr.Errors = errs
+	return apiErr
+}
+
+// typeErrors decodes every attribute of the JSON object in data on its own
+// into a new value of the type of v, since the decoder stops at the first
+// mismatch, and returns the attributes whose JSON type doesn't match.
+func typeErrors(data []byte, v any) []models.FieldError {
+	attrs := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	t := reflect.TypeOf(v).Elem()
+	errs := []models.FieldError{}
+	for _, name := range names {
+		attr, err := json.Marshal(map[string]json.RawMessage{name: attrs[name]})
+		if err != nil {
+			return nil
+		}
+		var typeErr *json.UnmarshalTypeError
+		if err = json.Unmarshal(attr, reflect.New(t).Interface()); !errors.As(err, &typeErr) {
+			continue
+		}
+		expected := typeErr.Type
+		if field, ok := jsonField(t, name); ok {
+			expected = field.Type
+		}
+		errs = append(errs, models.FieldError{
+			Field:   name,
+			Code:    models.CodeInvalidType,
+			Message: fmt.Sprintf("%s must be %s", name, jsonTypeName(expected)),
+		})
+	}
+	return errs
+}
+
+// jsonField returns the field of the struct type t decoded from the name
+// attribute.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if tag == name {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// jsonTypeName returns the JSON type expected for values of type t.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(jsonTypeName(t.Elem()), "a "), "an ") + "s"
+	case reflect.Map, reflect.Struct:
+		return "an object"
 	}
+	return "a number"
 }
 
 type ApiResponse struct {
-	StatusCode int  `json:"status_code"`
-	Data       any  `json:"data"`
-	NextPage   *int `json:"next_page_id,omitempty"`
+	StatusCode int `json:"status_code"`
+	Data       any `json:"data"`
+	*Page
 }
 
-func NewApiResponse(statusCode int, data any, nextPage *int) *ApiResponse {
+// Page holds the navigation of list responses. The cursors are left out when
+// there are no more rows in their direction, and the counts unless asked for
+// with include=total.
+type Page struct {
+	NextCursor *string `json:"next_cursor,omitempty"`
+	PrevCursor *string `json:"prev_cursor,omitempty"`
+	TotalCount *int    `json:"total_count,omitempty"`
+	PageCount  *int    `json:"page_count,omitempty"`
+	Links      *Links  `json:"links,omitempty"`
+}
+
+// Links holds the URLs of the first page of a list and of the pages around
+// the current one, also sent in the Link header.
+type Links struct {
+	First string `json:"first"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+}
+
+func NewApiResponse(statusCode int, data any, page *Page) *ApiResponse {
 	return &ApiResponse{
 		StatusCode: statusCode,
 		Data:       data,
-		NextPage:   nextPage,
+		Page:       page,
 	}
 }
 
 func WriteHttpResponse(w http.ResponseWriter, statusCode int, value any) error {
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	return json.NewEncoder(w).Encode(value)
+	return render.JSON(w, statusCode, value)
+}
+
+// listError returns the error of a failed list query, a 400 carrying the
+// reason when a filter or the sort param is invalid.
+func listError(err error, status int, msg string) *ApiError {
+	if errors.Is(err, db.ErrInvalidFilter) || errors.Is(err, db.ErrInvalidSort) {
+		return NewApiError(http.StatusBadRequest, err.Error())
+	}
+	return NewApiError(status, msg)
+}
+
+// readJSONBody returns the request body after checking it's declared as JSON.
+// Bodies over the limit set by the MaxBodySize middleware fail with 413.
+func readJSONBody(r *http.Request) ([]byte, *ApiError) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return nil, NewApiError(http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+	}
+	body, err := io.ReadAll(r.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, NewApiError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request body can't be larger than %d bytes", maxBytesErr.Limit))
+	}
+	if err != nil {
+		return nil, NewApiError(http.StatusBadRequest, "Invalid request body")
+	}
+	return body, nil
+}
+
+// decodeStrict decodes a single JSON value from data into v, rejecting
+// unknown attributes and anything after the value. Values of the wrong JSON
+// type are reported as a validation error with an entry per attribute.
+func decodeStrict(data []byte, v any) *ApiError {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			if errs := typeErrors(data, v); len(errs) > 0 {
+				return NewValidationError(errs)
+			}
+		}
+		return NewApiError(http.StatusBadRequest, "Invalid request body: "+strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return NewApiError(http.StatusBadRequest, "Invalid request body: unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// decodeJSONBody reads the request body and strictly decodes it into v.
+func decodeJSONBody(r *http.Request, v any) *ApiError {
+	body, apiErr := readJSONBody(r)
+	if apiErr != nil {
+		return apiErr
+	}
+	return decodeStrict(body, v)
 }
 
 func HTTPHandleFunc(f apiFunc, db db.ApiDB) http.HandlerFunc {
@@ -47,6 +205,10 @@ func HTTPHandleFunc(f apiFunc, db db.ApiDB) http.HandlerFunc {
 			// Handle Error
 			WriteHttpResponse(w, err.StatusCode, err)
 		} else {
+			if resp.Page != nil {
+				resp.Links = newLinks(r.URL, resp.Page)
+				w.Header().Set("Link", resp.Links.header())
+			}
 			WriteHttpResponse(w, resp.StatusCode, resp)
 		}
 	}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jcardenasc93/work-at-olist/app/middlewares"
+	"github.com/jcardenasc93/work-at-olist/app/render"
+)
+
+func TestRouterErrors(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(middlewares.Recoverer)
+	r.NotFound(render.NotFound)
+	r.MethodNotAllowed(render.MethodNotAllowed)
+	r.Route("/books", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	})
+
+	cases := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{http.MethodGet, "/nope", http.StatusNotFound},
+		{http.MethodGet, "/books/1/nope", http.StatusNotFound},
+		{http.MethodPatch, "/books/", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/books/", http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, nil)
+		resRecorder := httptest.NewRecorder()
+		r.ServeHTTP(resRecorder, req)
+		response := resRecorder.Result()
+		if response.StatusCode != c.status {
+			t.Errorf("Expected HTTP code %d for %s %s but got %d", c.status, c.method, c.url, response.StatusCode)
+		}
+		if contentType := response.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Expected JSON error for %s %s but got %s", c.method, c.url, contentType)
+		}
+		apiErr := decodeResponseBody[ApiError](t, response.Body)
+		if apiErr.StatusCode != c.status {
+			t.Errorf("Expected ApiError with status %d for %s %s but got %+v", c.status, c.method, c.url, apiErr)
+		}
+	}
+}
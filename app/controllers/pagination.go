@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+	mid "github.com/jcardenasc93/work-at-olist/app/middlewares"
+)
+
+// fetchPage reads a page of a keyset paginated list. It asks fetch for one
+// row more than the limit to tell whether there are rows left in the paging
+// direction, and returns the cursors pointing to the pages around it. key
+// returns the id of a row and the values of the sort fields besides it, and
+// count the number of rows matching the filters, only called when the
+// request includes the total.
+func fetchPage[T any](p *mid.PaginationVals, sort string, fetch func(*mid.PaginationVals) ([]T, error), count func() (int, error), key func(T) (float64, []any)) ([]T, *Page, error) {
+	if p.Cursor != nil && p.Cursor.Sort != sort {
+		return nil, nil, fmt.Errorf("%w: the cursor doesn't match the sort", db.ErrInvalidSort)
+	}
+	rows, err := fetch(&mid.PaginationVals{Cursor: p.Cursor, Limit: p.Limit + 1, Filter: p.Filter})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backwards := p.Cursor != nil && p.Cursor.Prev
+	more := len(rows) > p.Limit
+	if more && backwards {
+		rows = rows[len(rows)-p.Limit:]
+	} else if more {
+		rows = rows[:p.Limit]
+	}
+
+	page := new(Page)
+	if p.IncludeTotal {
+		total, err := count()
+		if err != nil {
+			return nil, nil, err
+		}
+		pages := (total + p.Limit - 1) / p.Limit
+		page.TotalCount, page.PageCount = &total, &pages
+	}
+	if len(rows) == 0 {
+		return rows, page, nil
+	}
+	// Coming from a cursor means there are rows on the other side of it
+	hasNext := more || backwards
+	hasPrev := (more && backwards) || (p.Cursor != nil && !backwards)
+	if hasNext {
+		id, values := key(rows[len(rows)-1])
+		page.NextCursor = newCursor(p, sort, id, values, false)
+	}
+	if hasPrev {
+		id, values := key(rows[0])
+		page.PrevCursor = newCursor(p, sort, id, values, true)
+	}
+	return rows, page, nil
+}
+
+func newCursor(p *mid.PaginationVals, sort string, id float64, key []any, prev bool) *string {
+	token := mid.EncodeCursor(&mid.Cursor{Sort: sort, Key: key, Id: id, Prev: prev, Filter: p.Filter})
+	return &token
+}
+
+// sortParam returns the sort of a list request, by id when not given.
+func sortParam(params url.Values) string {
+	if sort := params.Get(db.SortKey); sort != "" {
+		return sort
+	}
+	return "id"
+}
+
+// newLinks returns the links to the pages of a list. They keep every query
+// param of the request, so the filters, sort and limit carry over, but the
+// cursor, which is replaced by the one of each page.
+func newLinks(u *url.URL, page *Page) *Links {
+	link := func(cursor *string) string {
+		query := u.Query()
+		query.Del(mid.CursorKey)
+		if cursor != nil {
+			query.Set(mid.CursorKey, *cursor)
+		}
+		target := url.URL{Path: u.Path, RawQuery: query.Encode()}
+		return target.String()
+	}
+	links := &Links{First: link(nil)}
+	if page.NextCursor != nil {
+		links.Next = link(page.NextCursor)
+	}
+	if page.PrevCursor != nil {
+		links.Prev = link(page.PrevCursor)
+	}
+	return links
+}
+
+// header returns the links in the format of the RFC 8288 Link header.
+func (l *Links) header() string {
+	values := []string{}
+	for _, link := range []struct{ rel, url string }{{"next", l.Next}, {"prev", l.Prev}, {"first", l.First}} {
+		if link.url != "" {
+			values = append(values, fmt.Sprintf(`<%s>; rel="%s"`, link.url, link.rel))
+		}
+	}
+	return strings.Join(values, ", ")
+}
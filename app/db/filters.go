@@ -0,0 +1,245 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+// Filters of the list endpoints. Every filter is a query param named after
+// the field, optionally followed by an operator:
+//
+//	field=v           the default operator of the field, equality for most
+//	field=v1,v2,v3    any of the values (IN), for fields compared by equality
+//	field!=v          negation of the default operator, also field!=v1,v2
+//	field[op]=v       an explicit operator, one of eq, ne, gt, gte, lt, lte,
+//	                  in and nin, as long as the field allows it
+//
+// Conditions on different params are combined with AND. Params not naming a
+// filter field, like the pagination ones, are ignored.
+
+// ErrInvalidFilter is returned when a filter param can't be applied.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+type filterOp string
+
+const (
+	opEq       filterOp = "eq"
+	opNe       filterOp = "ne"
+	opGt       filterOp = "gt"
+	opGte      filterOp = "gte"
+	opLt       filterOp = "lt"
+	opLte      filterOp = "lte"
+	opIn       filterOp = "in"
+	opNin      filterOp = "nin"
+	opContains filterOp = "contains"
+)
+
+// comparisons holds the SQL of the single value operators.
+var comparisons = map[filterOp]string{
+	opEq:       "= ?",
+	opGt:       "> ?",
+	opGte:      ">= ?",
+	opLt:       "< ?",
+	opLte:      "<= ?",
+	opContains: "LIKE '%'||?||'%'",
+}
+
+// Operators allowed by the different kinds of fields, besides the negations.
+var (
+	textOps  = []filterOp{opContains}
+	idOps    = []filterOp{opEq, opIn}
+	rangeOps = []filterOp{opEq, opIn, opGt, opGte, opLt, opLte}
+)
+
+// filterField describes a field accepted as filter.
+type filterField struct {
+	// expr is the SQL condition with a %s verb where the comparison goes.
+	expr string
+	// ops lists the allowed operators, the first one is the default.
+	ops []filterOp
+	// numeric fields only accept integer values.
+	numeric bool
+	// normalize, when set, is applied to the values of text fields.
+	normalize func(string) string
+}
+
+func (f filterField) allows(op filterOp) bool {
+	for _, allowed := range f.ops {
+		if allowed == op {
+			return true
+		}
+	}
+	return false
+}
+
+// bookFilters are the filters of the books list.
+var bookFilters = map[string]filterField{
+	// name matches the books whose name contains the value, ignoring the
+	// case of ASCII letters, e.g. name=python or name!=draft.
+	"name": {expr: "name %s", ops: textOps},
+	// publication_year accepts exact years, lists and ranges, e.g.
+	// publication_year[gte]=1990&publication_year[lt]=2000.
+	"publication_year": {expr: "publication_year %s", ops: rangeOps, numeric: true},
+	// edition accepts exact editions, lists and ranges, e.g. edition=1,2,3.
+	"edition": {expr: "edition %s", ops: rangeOps, numeric: true},
+	// author matches the books written by any of the given author ids, e.g.
+	// author=17, or by none of them, e.g. author!=42.
+	"author": {expr: "id IN (SELECT book_id FROM author_book WHERE author_id %s)", ops: idOps, numeric: true},
+	// author_name matches the books with at least one author whose name
+	// contains the value, ignoring case and repeated whitespace, e.g.
+	// author_name=ramalho. It's compared with the normalized author names.
+	"author_name": {
+		expr: `id IN (SELECT ab.book_id FROM author_book ab JOIN author a ON a.id = ab.author_id
+                      WHERE COALESCE(a.name_key, lower(a.name)) %s)`,
+		ops:       textOps,
+		normalize: models.NormalizeAuthorName,
+	},
+}
+
+// authorFilters are the filters of the authors list.
+var authorFilters = map[string]filterField{
+	// name matches the authors whose name contains the value, ignoring the
+	// case of ASCII letters.
+	"name": {expr: "name %s", ops: textOps},
+}
+
+// filterCond is a parsed filter param.
+type filterCond struct {
+	field  string
+	op     filterOp
+	negate bool
+	values []any
+}
+
+// parseFilters returns the conditions of the params naming one of the fields.
+// The params are read in order so the generated queries are stable.
+func parseFilters(fields map[string]filterField, params url.Values) ([]filterCond, error) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	conds := []filterCond{}
+	for _, key := range keys {
+		name, op, negate := parseFilterKey(key)
+		field, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if op == "" {
+			op = field.ops[0]
+		}
+		if !field.allows(op) {
+			return nil, fmt.Errorf("%w: %s doesn't support the %s operator", ErrInvalidFilter, name, op)
+		}
+		for _, raw := range params[key] {
+			cond, err := parseFilterValues(name, field, op, negate, raw)
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, cond)
+		}
+	}
+	return conds, nil
+}
+
+// parseFilterKey splits a param like publication_year[gte] or author! into
+// the field name, operator and negation. ne and nin are turned into the
+// negation of eq and in.
+func parseFilterKey(key string) (name string, op filterOp, negate bool) {
+	if strings.HasSuffix(key, "!") {
+		return strings.TrimSuffix(key, "!"), "", true
+	}
+	open := strings.Index(key, "[")
+	if open < 0 || !strings.HasSuffix(key, "]") {
+		return key, "", false
+	}
+	name, op = key[:open], filterOp(key[open+1:len(key)-1])
+	switch op {
+	case opNe:
+		return name, opEq, true
+	case opNin:
+		return name, opIn, true
+	}
+	return name, op, false
+}
+
+func parseFilterValues(name string, field filterField, op filterOp, negate bool, raw string) (filterCond, error) {
+	cond := filterCond{field: name, op: op, negate: negate}
+	rawValues := []string{raw}
+	if op == opEq || op == opIn {
+		rawValues = strings.Split(raw, ",")
+		if len(rawValues) > 1 {
+			cond.op = opIn
+		}
+	}
+	for _, value := range rawValues {
+		value = strings.TrimSpace(value)
+		if !field.numeric {
+			if field.normalize != nil {
+				value = field.normalize(value)
+			}
+			cond.values = append(cond.values, value)
+			continue
+		}
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return cond, fmt.Errorf("%w: %s value %q isn't an integer", ErrInvalidFilter, name, value)
+		}
+		cond.values = append(cond.values, number)
+	}
+	return cond, nil
+}
+
+// sql returns the condition for the field as parameterized SQL.
+func (c filterCond) sql(field filterField) string {
+	comparison := comparisons[c.op]
+	if c.op == opIn {
+		comparison = "IN " + placeholders(len(c.values))
+	}
+	cond := fmt.Sprintf(field.expr, comparison)
+	if c.negate {
+		return fmt.Sprintf("NOT (%s)", cond)
+	}
+	return cond
+}
+
+// match tells whether any of the field values satisfies the condition. It
+// mirrors the SQL of the condition for the stores not backed by SQL.
+func (c filterCond) match(fieldValues ...any) bool {
+	matched := false
+	for _, fieldValue := range fieldValues {
+		for _, value := range c.values {
+			if compareFilterValue(c.op, fieldValue, value) {
+				matched = true
+			}
+		}
+	}
+	return matched != c.negate
+}
+
+func compareFilterValue(op filterOp, fieldValue any, value any) bool {
+	if text, ok := fieldValue.(string); ok {
+		return strings.Contains(strings.ToLower(text), strings.ToLower(value.(string)))
+	}
+	number := fieldValue.(float64)
+	target := float64(value.(int64))
+	switch op {
+	case opGt:
+		return number > target
+	case opGte:
+		return number >= target
+	case opLt:
+		return number < target
+	case opLte:
+		return number <= target
+	}
+	return number == target
+}
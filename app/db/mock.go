@@ -1,10 +1,13 @@
 package db
 
 import (
+	"cmp"
 	"context"
+	"fmt"
 	"net/url"
-	"strconv"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/jcardenasc93/work-at-olist/app/middlewares"
 	"github.com/jcardenasc93/work-at-olist/app/models"
@@ -14,9 +17,12 @@ type MockDB struct {
 	Authors      []*models.Author
 	Books        []*models.Book
 	AuthorsBooks map[float64][]float64
+	Checkpoints  map[string]*ImportCheckpoint
 }
 
-func NewMockDB() *MockDB { return &MockDB{AuthorsBooks: make(map[float64][]float64)} }
+func NewMockDB() *MockDB {
+	return &MockDB{AuthorsBooks: make(map[float64][]float64), Checkpoints: make(map[string]*ImportCheckpoint)}
+}
 
 func (m *MockDB) SetAuthors(authors []*models.Author) {
 	m.Authors = authors
@@ -36,6 +42,57 @@ func (m *MockDB) CreateAuthorBookTable() error { return nil }
 
 func (m *MockDB) InsertAuthor(string) error { return nil }
 
+func (m *MockDB) InsertAuthors(c context.Context, authors []*models.Author, onDup OnDuplicate, cp *ImportCheckpoint) (int, error) {
+	var matched int
+	for _, newAuthor := range authors {
+		name := newAuthor.Name
+		key := models.NormalizeAuthorName(name)
+		var existing *models.Author
+		for _, author := range m.Authors {
+			if models.NormalizeAuthorName(author.Name) == key {
+				existing = author
+				break
+			}
+		}
+		if existing == nil {
+			newAuthor.Id = uint64(len(m.Authors) + 1)
+			m.Authors = append(m.Authors, newAuthor)
+			continue
+		}
+		matched++
+		switch onDup {
+		case DuplicateKeep:
+			newAuthor.Id = existing.Id
+			*existing = *newAuthor
+		case DuplicateError:
+			return matched, fmt.Errorf("%w: %s", ErrDuplicateAuthor, name)
+		}
+	}
+	if cp != nil {
+		cp.Imported += len(authors) - matched
+		cp.Duplicated += matched
+		saved := *cp
+		m.Checkpoints[cp.Name] = &saved
+	}
+	return matched, nil
+}
+
+func (m *MockDB) CreateImportCheckpointTable() error { return nil }
+
+func (m *MockDB) FetchImportCheckpoint(c context.Context, name string) (*ImportCheckpoint, error) {
+	cp, ok := m.Checkpoints[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	saved := *cp
+	return &saved, nil
+}
+
+func (m *MockDB) DeleteImportCheckpoint(c context.Context, name string) error {
+	delete(m.Checkpoints, name)
+	return nil
+}
+
 func (m *MockDB) InsertBook(c context.Context, req *models.CreateBookReq) (*models.Book, error) {
 	book := models.NewBook(float64(len(m.Books)+1), req.Name, req.Edition, req.PubYear, req.Authors)
 	m.Books = append(m.Books, book)
@@ -43,80 +100,240 @@ func (m *MockDB) InsertBook(c context.Context, req *models.CreateBookReq) (*mode
 	return book, nil
 }
 
+func (m *MockDB) InsertBooks(c context.Context, reqs []*models.CreateBookReq) ([]*models.Book, error) {
+	books := make([]*models.Book, 0, len(reqs))
+	for _, req := range reqs {
+		book, err := m.InsertBook(c, req)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+func (m *MockDB) FetchAuthorIdsByName(c context.Context, names []string) (map[string]float64, error) {
+	ids := map[string]float64{}
+	for _, name := range names {
+		key := models.NormalizeAuthorName(name)
+		for _, author := range m.Authors {
+			if models.NormalizeAuthorName(author.Name) == key {
+				ids[key] = float64(author.Id)
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (m *MockDB) FetchMissingAuthorIds(c context.Context, ids []float64) ([]float64, error) {
+	missing := []float64{}
+	for _, id := range ids {
+		found := false
+		for _, author := range m.Authors {
+			if float64(author.Id) == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
 func (m *MockDB) FetchAuthors(pagination *middlewares.PaginationVals, vals url.Values) ([]*models.Author, error) {
-	const nameKey string = "name"
-	var authors []*models.Author
-	limit := pagination.Limit
-	pageId := pagination.PageId
+	authors, err := m.filterAuthors(vals)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(authors, authorSorts, vals.Get(SortKey), pagination)
+}
+
+func (m *MockDB) CountAuthors(vals url.Values) (int, error) {
+	authors, err := m.filterAuthors(vals)
+	return len(authors), err
+}
 
-	if vals.Has(nameKey) {
-		nameValue := string(vals.Get(nameKey))
-		authors = m.filterByName(nameValue)
-	} else {
-		authors = m.Authors
+func (m *MockDB) filterAuthors(vals url.Values) ([]*models.Author, error) {
+	filters := allowedFilters[*models.Author]{
+		params: map[string]func(*models.Author) []any{
+			"name": func(a *models.Author) []any { return []any{a.Name} },
+		},
 	}
+	return applyFilters(m.Authors, filters, authorFilters, vals)
+}
+
+func (m *MockDB) FetchBooks(pagination *middlewares.PaginationVals, vals url.Values) ([]*models.Book, error) {
+	return m.fetchBooks(pagination, vals, false)
+}
 
-	authors = authors[pageId:]
-	if limit < len(authors) {
-		return authors[:limit], nil
+func (m *MockDB) CountBooks(vals url.Values) (int, error) {
+	books, err := m.filterBooks(vals, false)
+	return len(books), err
+}
+
+func (m *MockDB) CountTrashedBooks(vals url.Values) (int, error) {
+	books, err := m.filterBooks(vals, true)
+	return len(books), err
+}
+
+func (m *MockDB) fetchBooks(pagination *middlewares.PaginationVals, vals url.Values, trashed bool) ([]*models.Book, error) {
+	books, err := m.filterBooks(vals, trashed)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(books, bookSorts, vals.Get(SortKey), pagination)
+}
+
+func (m *MockDB) filterBooks(vals url.Values, trashed bool) ([]*models.Book, error) {
+	filters := allowedFilters[*models.Book]{
+		params: map[string]func(*models.Book) []any{
+			"name":             func(b *models.Book) []any { return []any{b.Name} },
+			"publication_year": func(b *models.Book) []any { return []any{b.PubYear} },
+			"edition":          func(b *models.Book) []any { return []any{b.Edition} },
+			"author": func(b *models.Book) []any {
+				authors := []any{}
+				for _, author := range b.Authors {
+					authors = append(authors, author)
+				}
+				return authors
+			},
+			"author_name": func(b *models.Book) []any {
+				names := []any{}
+				for _, author := range m.Authors {
+					if containsFloat(b.Authors, float64(author.Id)) {
+						names = append(names, models.NormalizeAuthorName(author.Name))
+					}
+				}
+				return names
+			},
+		},
 	}
-	return authors, nil
+	return applyFilters(filterBooksByTrash(m.Books, trashed), filters, bookFilters, vals)
+}
+
+func (m *MockDB) FetchTrashedBooks(pagination *middlewares.PaginationVals, vals url.Values) ([]*models.Book, error) {
+	return m.fetchBooks(pagination, vals, true)
 }
 
-func filterBooksByName(books []*models.Book, name string) []*models.Book {
+func filterBooksByTrash(books []*models.Book, trashed bool) []*models.Book {
 	result := []*models.Book{}
 	for _, book := range books {
-		if strings.Contains(book.Name, name) {
+		if (book.DeletedAt != nil) == trashed {
 			result = append(result, book)
 		}
 	}
 	return result
+}
 
+func (m *MockDB) FetchBook(c context.Context, id float64) (*models.Book, error) {
+	for _, book := range m.Books {
+		if book.Id == id && book.DeletedAt == nil {
+			return book, nil
+		}
+	}
+	return nil, ErrNotFound
 }
 
-func filterBooksByPubYear(books []*models.Book, year string) []*models.Book {
-	y, _ := strconv.Atoi(year)
-	value := float64(y)
-	result := []*models.Book{}
+func (m *MockDB) UpdateBook(c context.Context, id float64, req *models.UpdateBookReq) (*models.Book, error) {
+	book, err := m.FetchBook(c, id)
+	if err != nil {
+		return nil, err
+	}
+	authors := book.Authors
+	if req.Authors != nil {
+		authors = append([]float64{}, *req.Authors...)
+	}
+	for _, author := range req.AddAuthors {
+		if !containsFloat(authors, author) {
+			authors = append(authors, author)
+		}
+	}
+	kept := []float64{}
+	for _, author := range authors {
+		if !containsFloat(req.RemoveAuthors, author) {
+			kept = append(kept, author)
+		}
+	}
+	if req.ChangesAuthors() && len(kept) == 0 {
+		return nil, ErrNoAuthors
+	}
 
-	for _, book := range books {
-		if book.PubYear == value {
-			result = append(result, book)
+	if req.Name != nil {
+		book.Name = *req.Name
+	}
+	if req.Edition != nil {
+		book.Edition = *req.Edition
+	}
+	if req.PubYear != nil {
+		book.PubYear = *req.PubYear
+	}
+	book.Authors = kept
+	m.AuthorsBooks[book.Id] = kept
+	return book, nil
+}
+
+func (m *MockDB) DeleteBook(c context.Context, id float64) error {
+	for i, book := range m.Books {
+		if book.Id == id {
+			m.Books = append(m.Books[:i], m.Books[i+1:]...)
+			delete(m.AuthorsBooks, id)
+			return nil
 		}
 	}
-	return result
+	return ErrNotFound
+}
 
+func (m *MockDB) TrashBook(c context.Context, id float64) error {
+	book, err := m.FetchBook(c, id)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	book.DeletedAt = &now
+	return nil
 }
 
-func (m *MockDB) FetchBooks(pagination *middlewares.PaginationVals, vals url.Values) ([]*models.Book, error) {
-	const nameKey string = "name"
-	const pubYearKey string = "publication_year"
-	const editionKey string = "edition"
-	const authorKey string = "author"
-	var books []*models.Book
-	limit := pagination.Limit
-	pageId := pagination.PageId
+func (m *MockDB) RestoreBook(c context.Context, id float64) (*models.Book, error) {
+	for _, book := range m.Books {
+		if book.Id == id && book.DeletedAt != nil {
+			book.DeletedAt = nil
+			return book, nil
+		}
+	}
+	return nil, ErrNotFound
+}
 
-	filters := allowedFilters[*models.Book]{
-		params: map[string]func([]*models.Book, string) []*models.Book{
-			nameKey:    filterBooksByName,
-			pubYearKey: filterBooksByPubYear,
-		},
+func (m *MockDB) PurgeBooks(c context.Context, before time.Time) (int, error) {
+	books := []*models.Book{}
+	for _, book := range m.Books {
+		if book.DeletedAt != nil && book.DeletedAt.Before(before) {
+			delete(m.AuthorsBooks, book.Id)
+			continue
+		}
+		books = append(books, book)
 	}
-	books = applyFilters(m.Books, filters, vals)
+	purged := len(m.Books) - len(books)
+	m.Books = books
+	return purged, nil
+}
 
-	books = books[pageId:]
-	if limit < len(books) {
-		return books[:limit], nil
+func containsFloat(values []float64, value float64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
-	return books, nil
+	return false
 }
 
 func (m *MockDB) FetchAuthorsForBooks([]*models.Book) ([]*models.Book, error) {
 	return m.Books, nil
 }
 
-func (m *MockDB) applyQueryParams(baseQuery string, q allowedQParams, params url.Values) (query string, paramVals []any) {
+func (m *MockDB) applyQueryParams(baseQuery string, q allowedQParams, params url.Values) (query string, paramVals []any, err error) {
 	return
 }
 
@@ -124,29 +341,101 @@ type modelType interface {
 	*models.Author | *models.Book
 }
 
+// allowedFilters maps every filter field to the values of a record it's
+// compared with.
 type allowedFilters[T modelType] struct {
-	params map[string]func([]T, string) []T
+	params map[string]func(T) []any
 }
 
-func applyFilters[T modelType](data []T, q allowedFilters[T], vals url.Values) []T {
-	// result := []T{}
-	for key, fun := range q.params {
-		if vals.Has(key) {
-			keyVal := vals.Get(key)
-			data = fun(data, keyVal)
+// applyFilters keeps the records matching every filter param, following the
+// same grammar of the SQL stores.
+func applyFilters[T modelType](data []T, q allowedFilters[T], fields map[string]filterField, vals url.Values) ([]T, error) {
+	conds, err := parseFilters(fields, vals)
+	if err != nil {
+		return nil, err
+	}
+	result := []T{}
+	for _, record := range data {
+		matched := true
+		for _, cond := range conds {
+			matched = matched && cond.match(q.params[cond.field](record)...)
+		}
+		if matched {
+			result = append(result, record)
 		}
 	}
+	return result, nil
+}
 
-	return data
+func (m *MockDB) sortAndLimit(string, []sortField, *middlewares.PaginationVals) (string, []any, error) {
+	return "", nil, nil
 }
 
-func (m *MockDB) filterByName(name string) (authors []*models.Author) {
-	for _, author := range m.Authors {
-		if strings.Contains(author.Name, name) {
-			authors = append(authors, author)
+// paginate sorts the records and returns the page after or before the
+// pagination cursor, like the keyset pagination of the SQL stores.
+func paginate[T modelType](data []T, allowed map[string]func(T) any, sort string, pagination *middlewares.PaginationVals) ([]T, error) {
+	sorts, err := parseSort(allowed, sort)
+	if err != nil {
+		return nil, err
+	}
+	key := func(record T) []any {
+		values := []any{}
+		for _, field := range sorts {
+			values = append(values, allowed[field.name](record))
 		}
+		return values
 	}
-	return authors
+	sorted := slices.Clone(data)
+	slices.SortStableFunc(sorted, func(a, b T) int {
+		return compareKeys(sorts, key(a), key(b))
+	})
+
+	page := []T{}
+	c := pagination.Cursor
+	var cursorVals []any
+	if c != nil {
+		if cursorVals, err = cursorValues(sorts, c); err != nil {
+			return nil, err
+		}
+	}
+	for _, record := range sorted {
+		if c == nil {
+			page = append(page, record)
+			continue
+		}
+		diff := compareKeys(sorts, key(record), cursorVals)
+		if (c.Prev && diff < 0) || (!c.Prev && diff > 0) {
+			page = append(page, record)
+		}
+	}
+	if len(page) <= pagination.Limit {
+		return page, nil
+	}
+	if c != nil && c.Prev {
+		return page[len(page)-pagination.Limit:], nil
+	}
+	return page[:pagination.Limit], nil
 }
 
-func (m *MockDB) sortAndLimit(string) string { return "" }
+// compareKeys compares the values of the sort fields of two records in the
+// sort order.
+func compareKeys(sorts []sortField, a, b []any) int {
+	for i, field := range sorts {
+		var diff int
+		switch val := a[i].(type) {
+		case float64:
+			other, _ := b[i].(float64)
+			diff = cmp.Compare(val, other)
+		case string:
+			other, _ := b[i].(string)
+			diff = strings.Compare(val, other)
+		}
+		if field.desc {
+			diff = -diff
+		}
+		if diff != 0 {
+			return diff
+		}
+	}
+	return 0
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	m "github.com/jcardenasc93/work-at-olist/app/middlewares"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+// SortKey is the query param with the ordering of the list endpoints, a comma
+// separated list of fields where a - prefix sorts the field descending, e.g.
+// sort=-publication_year,name. The id is always added as the last sort field
+// so the order is total and the keyset pagination stable.
+const SortKey = "sort"
+
+const idSort = "id"
+
+// ErrInvalidSort is returned when the sort param can't be applied.
+var ErrInvalidSort = errors.New("invalid sort")
+
+// bookSorts are the fields the books can be sorted by, with their values.
+var bookSorts = map[string]func(*models.Book) any{
+	idSort:             func(b *models.Book) any { return b.Id },
+	"name":             func(b *models.Book) any { return b.Name },
+	"edition":          func(b *models.Book) any { return b.Edition },
+	"publication_year": func(b *models.Book) any { return b.PubYear },
+}
+
+// authorSorts are the fields the authors can be sorted by, with their values.
+var authorSorts = map[string]func(*models.Author) any{
+	idSort: func(a *models.Author) any { return float64(a.Id) },
+	"name": func(a *models.Author) any { return a.Name },
+}
+
+// sortField is a column of the ORDER BY of a list.
+type sortField struct {
+	name string
+	desc bool
+}
+
+// parseSort returns the sort fields of the param, ending with the id.
+func parseSort[T any](allowed map[string]func(T) any, param string) ([]sortField, error) {
+	sorts := []sortField{}
+	seen := map[string]bool{}
+	if param != "" {
+		for _, name := range strings.Split(param, ",") {
+			field := sortField{name: strings.TrimSpace(name)}
+			if strings.HasPrefix(field.name, "-") {
+				field = sortField{name: field.name[1:], desc: true}
+			}
+			if _, ok := allowed[field.name]; !ok {
+				return nil, fmt.Errorf("%w: can't sort by %q", ErrInvalidSort, field.name)
+			}
+			if seen[field.name] {
+				return nil, fmt.Errorf("%w: %s is repeated", ErrInvalidSort, field.name)
+			}
+			seen[field.name] = true
+			sorts = append(sorts, field)
+		}
+	}
+	if !seen[idSort] {
+		sorts = append(sorts, sortField{name: idSort})
+	}
+	return sorts, nil
+}
+
+// cursorValues returns the values of the sort fields at the cursor position.
+func cursorValues(sorts []sortField, c *m.Cursor) ([]any, error) {
+	values := []any{}
+	key := c.Key
+	for _, field := range sorts {
+		if field.name == idSort {
+			values = append(values, c.Id)
+			continue
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("%w: the cursor doesn't match the sort", ErrInvalidSort)
+		}
+		values = append(values, key[0])
+		key = key[1:]
+	}
+	if len(key) > 0 {
+		return nil, fmt.Errorf("%w: the cursor doesn't match the sort", ErrInvalidSort)
+	}
+	return values, nil
+}
+
+// cursorKey returns the values of the sort fields of record but the id, the
+// key of the cursors pointing to it.
+func cursorKey[T any](allowed map[string]func(T) any, sort string, record T) []any {
+	sorts, err := parseSort(allowed, sort)
+	if err != nil {
+		return nil
+	}
+	key := []any{}
+	for _, field := range sorts {
+		if field.name != idSort {
+			key = append(key, allowed[field.name](record))
+		}
+	}
+	return key
+}
+
+// BookCursorKey returns the cursor key of book in the given sort.
+func BookCursorKey(sort string, book *models.Book) []any {
+	return cursorKey(bookSorts, sort, book)
+}
+
+// AuthorCursorKey returns the cursor key of author in the given sort.
+func AuthorCursorKey(sort string, author *models.Author) []any {
+	return cursorKey(authorSorts, sort, author)
+}
+
+// keysetCond returns the SQL condition selecting the rows after values in
+// the sort order, or before them when prev is set, as the OR of every prefix
+// of the sort fields: (a > ?) OR (a = ? AND b > ?) OR ...
+func keysetCond(sorts []sortField, values []any, prev bool) (string, []any) {
+	conds := []string{}
+	condVals := []any{}
+	for i, field := range sorts {
+		parts := []string{}
+		for j := 0; j < i; j++ {
+			parts = append(parts, sorts[j].name+" = ?")
+			condVals = append(condVals, values[j])
+		}
+		op := ">"
+		if field.desc != prev {
+			op = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s ?", field.name, op))
+		condVals = append(condVals, values[i])
+		conds = append(conds, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", condVals
+}
+
+// orderBy returns the ORDER BY clause of the sort fields, reversed for the
+// rows read backwards from a cursor.
+func orderBy(sorts []sortField, prev bool) string {
+	cols := []string{}
+	for _, field := range sorts {
+		col := field.name
+		if field.desc != prev {
+			col += " DESC"
+		}
+		cols = append(cols, col)
+	}
+	return "ORDER BY " + strings.Join(cols, ", ")
+}
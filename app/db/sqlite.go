@@ -9,6 +9,9 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"slices"
+	"strings"
+	"time"
 
 	m "github.com/jcardenasc93/work-at-olist/app/middlewares"
 	"github.com/jcardenasc93/work-at-olist/app/models"
@@ -16,8 +19,32 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// DBNameEnv is the environment variable holding the SQLite database file.
+const DBNameEnv = "dbName"
+
+// sqliteTimestamp is the layout of CURRENT_TIMESTAMP, so times written by Go
+// compare correctly with the ones set by SQLite.
+const sqliteTimestamp = "2006-01-02 15:04:05"
+
+// foreignKeysParam turns on the foreign keys enforcement in every connection,
+// SQLite leaves it off by default.
+const foreignKeysParam = "_foreign_keys=on"
+
+const createAuthorBookTable = `
+    CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        author_id INTEGER,
+        book_id INTEGER,
+        FOREIGN KEY(author_id) REFERENCES author(id)
+        ON DELETE NO ACTION,
+        FOREIGN KEY(book_id) REFERENCES book(id)
+        ON DELETE CASCADE
+    )`
+
 type SQLiteDB struct {
-	db *sql.DB
+	db             *sql.DB
+	authorStmt     *sql.Stmt
+	authorKeepStmt *sql.Stmt
 }
 
 func NewSQLiteDB() (*SQLiteDB, error) {
@@ -26,11 +53,34 @@ func NewSQLiteDB() (*SQLiteDB, error) {
 		return nil, err
 	}
 
-	dbName := os.Getenv("dbName")
+	return OpenSQLiteDB(os.Getenv(DBNameEnv))
+}
+
+// ResolveName returns the database file given with a command -db flag, or
+// the one in $dbName when the flag is empty. The .env file is optional for the
+// commands, since the environment may already be set.
+func ResolveName(flagVal string) (string, error) {
+	if flagVal != "" {
+		return flagVal, nil
+	}
+	godotenv.Load()
+	if dbName := os.Getenv(DBNameEnv); dbName != "" {
+		return dbName, nil
+	}
+	return "", errors.New("missing -db value and $" + DBNameEnv + " is not set")
+}
+
+// OpenSQLiteDB opens a connection to the SQLite database stored in dbName
+// without reading any environment file.
+func OpenSQLiteDB(dbName string) (*SQLiteDB, error) {
 	if dbName == "" {
 		return nil, errors.New("DB name couldn't be empty")
 	}
-	dbConn, err := sql.Open("sqlite3", dbName)
+	sep := "?"
+	if strings.Contains(dbName, "?") {
+		sep = "&"
+	}
+	dbConn, err := sql.Open("sqlite3", dbName+sep+foreignKeysParam)
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +123,11 @@ func (sq *SQLiteDB) CreateAuthorTable() error {
 	createAuthorsTable := `
     CREATE TABLE IF NOT EXISTS author (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
-        name VARCHAR(64) NOT NULL
+        name VARCHAR(64) NOT NULL,
+        name_key VARCHAR(64),
+        birth_year INTEGER,
+        nationality VARCHAR(64),
+        external_id VARCHAR(64)
     )
     `
 
@@ -87,7 +141,73 @@ func (sq *SQLiteDB) CreateAuthorTable() error {
 		return err
 	}
 
-	return nil
+	err = sq.migrateAuthorNameKey()
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"birth_year INTEGER", "nationality VARCHAR(64)", "external_id VARCHAR(64)"} {
+		err = sq.addColumnIfMissing("author", column)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = sq.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS author_name_key_idx ON author (name_key)`)
+	return err
+}
+
+// migrateAuthorNameKey adds and fills the name_key column on author tables
+// created before it existed. Authors already duplicated keep a NULL key in
+// every row but the first one, so they don't block the unique index.
+func (sq *SQLiteDB) migrateAuthorNameKey() error {
+	found, err := sq.hasColumn("author", "name_key")
+	if err != nil || found {
+		return err
+	}
+	log.Println("Adding name_key column to author table...")
+
+	tx, err := sq.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`ALTER TABLE author ADD COLUMN name_key VARCHAR(64)`); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`SELECT id, name FROM author ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	keys := map[string]uint64{}
+	for rows.Next() {
+		var id uint64
+		var name string
+		if err = rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		key := models.NormalizeAuthorName(name)
+		if _, ok := keys[key]; !ok {
+			keys[key] = id
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`UPDATE author SET name_key = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for key, id := range keys {
+		if _, err = stmt.Exec(key, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (sq *SQLiteDB) CreateBookTable() error {
@@ -98,7 +218,8 @@ func (sq *SQLiteDB) CreateBookTable() error {
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         name VARCHAR(80) NOT NULL,
         edition INTEGER NOT NULL,
-        publication_year INTEGER NOT NULL
+        publication_year INTEGER NOT NULL,
+        deleted_at DATETIME
     )
     `
 
@@ -110,22 +231,18 @@ func (sq *SQLiteDB) CreateBookTable() error {
 	if err != nil {
 		return err
 	}
-	return nil
+	if err = sq.addColumnIfMissing("book", "deleted_at DATETIME"); err != nil {
+		return err
+	}
+	// Every list splits the books on deleted_at, the index lets the counts
+	// skip reading the table
+	_, err = sq.db.Exec(`CREATE INDEX IF NOT EXISTS book_deleted_at_idx ON book (deleted_at)`)
+	return err
 }
 
 func (sq *SQLiteDB) CreateAuthorBookTable() error {
 	log.Println("Creating Authors-Books relationship table...")
-	createAuthorsBooksTable := `
-    CREATE TABLE IF NOT EXISTS author_book (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        author_id INTEGER,
-        book_id INTEGER,
-        FOREIGN KEY(author_id) REFERENCES author(id)
-        ON DELETE NO ACTION,
-        FOREIGN KEY(book_id) REFERENCES book(id)
-        ON DELETE NO ACTION
-    )`
-	stmt, err := sq.db.Prepare(createAuthorsBooksTable)
+	stmt, err := sq.db.Prepare(fmt.Sprintf(createAuthorBookTable, "author_book"))
 	if err != nil {
 		return err
 	}
@@ -133,12 +250,61 @@ func (sq *SQLiteDB) CreateAuthorBookTable() error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return sq.migrateAuthorBookCascade()
+}
+
+// migrateAuthorBookCascade rebuilds author_book tables created without the
+// ON DELETE CASCADE of book_id, since SQLite can't alter a foreign key.
+// Relationships already pointing to missing authors or books are dropped.
+func (sq *SQLiteDB) migrateAuthorBookCascade() error {
+	var onDelete string
+	err := sq.db.QueryRow(`SELECT on_delete FROM pragma_foreign_key_list('author_book') WHERE "table" = 'book'`).Scan(&onDelete)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if onDelete == "CASCADE" {
+		return nil
+	}
+	log.Println("Adding ON DELETE CASCADE to author_book table...")
+
+	ctx := context.Background()
+	// foreign_keys can't be switched inside a transaction, so the whole
+	// migration runs on a single connection with the enforcement off
+	conn, err := sq.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		fmt.Sprintf(createAuthorBookTable, "author_book_new"),
+		`INSERT INTO author_book_new (id, author_id, book_id)
+         SELECT id, author_id, book_id FROM author_book
+         WHERE author_id IN (SELECT id FROM author) AND book_id IN (SELECT id FROM book)`,
+		`DROP TABLE author_book`,
+		`ALTER TABLE author_book_new RENAME TO author_book`,
+	}
+	for _, stmt := range stmts {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (sq *SQLiteDB) InsertAuthor(authorName string) error {
 	insertAuthorStmt := `
-    INSERT INTO author (name) VALUES (?)
+    INSERT INTO author (name, name_key) VALUES (?, ?)
     `
 	stmt, err := sq.db.Prepare(insertAuthorStmt)
 	if err != nil {
@@ -146,7 +312,7 @@ func (sq *SQLiteDB) InsertAuthor(authorName string) error {
 		return err
 	}
 
-	_, err = stmt.Exec(authorName)
+	_, err = stmt.Exec(authorName, models.NormalizeAuthorName(authorName))
 	if err != nil {
 		log.Fatalf("failing execution: %s", err.Error())
 		return err
@@ -154,18 +320,177 @@ func (sq *SQLiteDB) InsertAuthor(authorName string) error {
 	return nil
 }
 
+func (sq *SQLiteDB) hasColumn(table string, column string) (bool, error) {
+	var found int
+	err := sq.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&found)
+	return found > 0, err
+}
+
+// addColumnIfMissing adds the column described by definition, a column name
+// followed by its type, to tables created before it existed.
+func (sq *SQLiteDB) addColumnIfMissing(table string, definition string) error {
+	column := strings.Fields(definition)[0]
+	found, err := sq.hasColumn(table, column)
+	if err != nil || found {
+		return err
+	}
+	log.Printf("Adding %s column to %s table...", column, table)
+	_, err = sq.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, definition))
+	return err
+}
+
+// InsertAuthors stores the given authors in a single transaction. The insert
+// statements are prepared once and reused by every following call, so callers
+// can stream big imports through it in batches. Authors whose normalized name
+// matches an existing one are handled according to onDup, and the number of
+// those matches is returned. A non nil checkpoint is saved in the same
+// transaction.
+func (sq *SQLiteDB) InsertAuthors(ctx context.Context, authors []*models.Author, onDup OnDuplicate, cp *ImportCheckpoint) (int, error) {
+	if len(authors) == 0 && cp == nil {
+		return 0, nil
+	}
+	if err := sq.prepareAuthorStmts(ctx); err != nil {
+		log.Printf("Failing preparing insert author statements: %s\n", err.Error())
+		return 0, err
+	}
+
+	tx, err := sq.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Failing creating SQL Tx: %s\n", err.Error())
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	insertStmt := tx.StmtContext(ctx, sq.authorStmt)
+	defer insertStmt.Close()
+	updateStmt := tx.StmtContext(ctx, sq.authorKeepStmt)
+	defer updateStmt.Close()
+
+	var matched int
+	for _, author := range authors {
+		key := models.NormalizeAuthorName(author.Name)
+		result, err := insertStmt.ExecContext(ctx, author.Name, key, author.BirthYear, author.Nationality, author.ExternalId)
+		if err != nil {
+			log.Printf("Failing inserting author %q: %s\n", author.Name, err.Error())
+			return matched, err
+		}
+		if inserted, _ := result.RowsAffected(); inserted > 0 {
+			continue
+		}
+
+		matched++
+		switch onDup {
+		case DuplicateKeep:
+			_, err = updateStmt.ExecContext(ctx, author.Name, author.BirthYear, author.Nationality, author.ExternalId, key)
+			if err != nil {
+				log.Printf("Failing updating author %q: %s\n", author.Name, err.Error())
+				return matched, err
+			}
+		case DuplicateError:
+			return matched, fmt.Errorf("%w: %s", ErrDuplicateAuthor, author.Name)
+		}
+	}
+	if cp != nil {
+		cp.Imported += len(authors) - matched
+		cp.Duplicated += matched
+		_, err = tx.ExecContext(ctx, `INSERT OR REPLACE INTO import_checkpoint
+                                      (name, input_hash, input_offset, read, skipped, imported, duplicated)
+                                      VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			cp.Name, cp.InputHash, cp.Offset, cp.Read, cp.Skipped, cp.Imported, cp.Duplicated)
+		if err != nil {
+			log.Printf("Failing saving import checkpoint: %s\n", err.Error())
+			return matched, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failing commit changes in db: %s\n", err.Error())
+		return matched, err
+	}
+	return matched, nil
+}
+
+// CreateImportCheckpointTable creates the table holding the progress of the
+// imports, see InsertAuthors.
+func (sq *SQLiteDB) CreateImportCheckpointTable() error {
+	_, err := sq.db.Exec(`
+    CREATE TABLE IF NOT EXISTS import_checkpoint (
+        name TEXT PRIMARY KEY,
+        input_hash TEXT NOT NULL,
+        input_offset INTEGER NOT NULL,
+        read INTEGER NOT NULL,
+        skipped INTEGER NOT NULL,
+        imported INTEGER NOT NULL,
+        duplicated INTEGER NOT NULL
+    )
+    `)
+	return err
+}
+
+// FetchImportCheckpoint returns the checkpoint saved with the given name, or
+// ErrNotFound when there is none.
+func (sq *SQLiteDB) FetchImportCheckpoint(ctx context.Context, name string) (*ImportCheckpoint, error) {
+	cp := &ImportCheckpoint{Name: name}
+	err := sq.db.QueryRowContext(ctx, `SELECT input_hash, input_offset, read, skipped, imported, duplicated
+                                       FROM import_checkpoint WHERE name = ?`, name).
+		Scan(&cp.InputHash, &cp.Offset, &cp.Read, &cp.Skipped, &cp.Imported, &cp.Duplicated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// DeleteImportCheckpoint removes the checkpoint of a finished import.
+func (sq *SQLiteDB) DeleteImportCheckpoint(ctx context.Context, name string) error {
+	_, err := sq.db.ExecContext(ctx, `DELETE FROM import_checkpoint WHERE name = ?`, name)
+	return err
+}
+
+func (sq *SQLiteDB) prepareAuthorStmts(ctx context.Context) error {
+	var err error
+	if sq.authorStmt == nil {
+		sq.authorStmt, err = sq.db.PrepareContext(ctx, `INSERT INTO author (name, name_key, birth_year, nationality, external_id)
+                                                        VALUES (?, ?, ?, ?, ?)
+                                                        ON CONFLICT (name_key) DO NOTHING`)
+		if err != nil {
+			return err
+		}
+	}
+	if sq.authorKeepStmt == nil {
+		sq.authorKeepStmt, err = sq.db.PrepareContext(ctx, `UPDATE author SET name = ?,
+                                                            birth_year = COALESCE(?, birth_year),
+                                                            nationality = COALESCE(?, nationality),
+                                                            external_id = COALESCE(?, external_id)
+                                                            WHERE name_key = ?`)
+	}
+	return err
+}
+
 func (sq *SQLiteDB) InsertBook(ctx context.Context, bookData *models.CreateBookReq) (*models.Book, error) {
+	books, err := sq.InsertBooks(ctx, []*models.CreateBookReq{bookData})
+	if err != nil {
+		return nil, err
+	}
+	return books[0], nil
+}
+
+// InsertBooks stores the given books and their authors relationships in a
+// single transaction, so either every book is created or none of them.
+func (sq *SQLiteDB) InsertBooks(ctx context.Context, booksData []*models.CreateBookReq) ([]*models.Book, error) {
 	insertBookStmt := `INSERT INTO book (name, edition, publication_year)
                        VALUES (?, ?, ?)`
 	insertAuthorBookStmt := `INSERT INTO author_book (author_id, book_id)
                              VALUES (?, ?)`
 
 	tx, err := sq.db.BeginTx(ctx, nil)
-	defer tx.Rollback()
 	if err != nil {
 		log.Printf("Failing creating SQL Tx: %s\n", err.Error())
 		return nil, err
 	}
+	defer tx.Rollback()
 
 	bookStmt, err := tx.Prepare(insertBookStmt)
 	if err != nil {
@@ -173,64 +498,347 @@ func (sq *SQLiteDB) InsertBook(ctx context.Context, bookData *models.CreateBookR
 		return nil, err
 	}
 	defer bookStmt.Close()
-	result, err := bookStmt.ExecContext(ctx, bookData.Name, bookData.Edition, bookData.PubYear)
+	authorBookStmt, err := tx.Prepare(insertAuthorBookStmt)
 	if err != nil {
-		log.Printf("Failing inserting new book. \nData provided: %v\n%s", bookData, err.Error())
+		log.Printf("Failing preraring new author_book statement:%s\n", err.Error())
 		return nil, err
 	}
+	defer authorBookStmt.Close()
 
-	bookId, err := result.LastInsertId()
-	authorBookStmt, err := tx.Prepare(insertAuthorBookStmt)
+	books := make([]*models.Book, 0, len(booksData))
+	for _, bookData := range booksData {
+		result, err := bookStmt.ExecContext(ctx, bookData.Name, bookData.Edition, bookData.PubYear)
+		if err != nil {
+			log.Printf("Failing inserting new book. \nData provided: %v\n%s", bookData, err.Error())
+			return nil, err
+		}
+
+		bookId, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		for _, author := range bookData.Authors {
+			_, err = authorBookStmt.ExecContext(ctx, author, bookId)
+			if err != nil {
+				log.Printf("Failing inserting author_book relationship with author_id: %v, book_id: %v.\n%s", author, bookId, err.Error())
+				return nil, err
+			}
+		}
+		book := models.NewBook(float64(bookId), bookData.Name, bookData.Edition, bookData.PubYear, bookData.Authors)
+		books = append(books, book)
+	}
+	// Commit the transaction.
+	if err = tx.Commit(); err != nil {
+		log.Printf("Failing commit changes in db: %s\n", err.Error())
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// UpdateBook applies the changes in bookData to the book with the given id,
+// including its authors relationships, in a single transaction. It returns
+// the updated book or ErrNotFound when it doesn't exist.
+func (sq *SQLiteDB) UpdateBook(ctx context.Context, id float64, bookData *models.UpdateBookReq) (*models.Book, error) {
+	tx, err := sq.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Failing preraring new author_book statement:%s\n", err.Error())
+		log.Printf("Failing creating SQL Tx: %s\n", err.Error())
 		return nil, err
 	}
-	for _, author := range bookData.Authors {
-		_, err = authorBookStmt.ExecContext(ctx, author, bookId)
+	defer tx.Rollback()
+
+	var found float64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM book WHERE id = ? AND deleted_at IS NULL`, id).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sets := []string{}
+	vals := []any{}
+	if bookData.Name != nil {
+		sets = append(sets, "name = ?")
+		vals = append(vals, *bookData.Name)
+	}
+	if bookData.Edition != nil {
+		sets = append(sets, "edition = ?")
+		vals = append(vals, *bookData.Edition)
+	}
+	if bookData.PubYear != nil {
+		sets = append(sets, "publication_year = ?")
+		vals = append(vals, *bookData.PubYear)
+	}
+	if len(sets) > 0 {
+		query := fmt.Sprintf("UPDATE book SET %s WHERE id = ?", strings.Join(sets, ", "))
+		if _, err = tx.ExecContext(ctx, query, append(vals, id)...); err != nil {
+			log.Printf("Failing updating book %v: %s\n", id, err.Error())
+			return nil, err
+		}
+	}
+
+	if bookData.Authors != nil {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM author_book WHERE book_id = ?`, id); err != nil {
+			return nil, err
+		}
+		if err = sq.addBookAuthors(ctx, tx, id, *bookData.Authors); err != nil {
+			return nil, err
+		}
+	}
+	if err = sq.addBookAuthors(ctx, tx, id, bookData.AddAuthors); err != nil {
+		return nil, err
+	}
+	for _, author := range bookData.RemoveAuthors {
+		_, err = tx.ExecContext(ctx, `DELETE FROM author_book WHERE book_id = ? AND author_id = ?`, id, author)
 		if err != nil {
-			log.Printf("Failing inserting author_book relationship with author_id: %v, book_id: %v.\n%s", author, bookId, err.Error())
+			log.Printf("Failing removing author_book relationship with author_id: %v, book_id: %v.\n%s", author, id, err.Error())
 			return nil, err
 		}
 	}
-	// Commit the transaction.
+	if bookData.ChangesAuthors() {
+		var authors int
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM author_book WHERE book_id = ?`, id).Scan(&authors)
+		if err != nil {
+			return nil, err
+		}
+		if authors == 0 {
+			return nil, ErrNoAuthors
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		log.Printf("Failing commit changes in db: %s\n", err.Error())
 		return nil, err
 	}
+	return sq.FetchBook(ctx, id)
+}
 
-	book := models.NewBook(float64(bookId), bookData.Name, bookData.Edition, bookData.PubYear, bookData.Authors)
-	return book, nil
+// DeleteBook permanently removes the book with the given id, trashed or not.
+// Its author_book rows are removed by the ON DELETE CASCADE of the foreign key
+// in the same statement.
+func (sq *SQLiteDB) DeleteBook(ctx context.Context, id float64) error {
+	res, err := sq.db.ExecContext(ctx, `DELETE FROM book WHERE id = ?`, id)
+	if err != nil {
+		log.Printf("Failing deleting book %v: %s\n", id, err.Error())
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-func (sq *SQLiteDB) filterByName(baseQuery string) (query string) {
-	const filter string = `AND name LIKE '%'||?||'%'`
-	query = fmt.Sprintf("%s %s", baseQuery, filter)
-	return
+// TrashBook soft deletes the book with the given id by setting its
+// deleted_at timestamp. Books already in the trash are reported as not found.
+func (sq *SQLiteDB) TrashBook(ctx context.Context, id float64) error {
+	res, err := sq.db.ExecContext(ctx, `UPDATE book SET deleted_at = CURRENT_TIMESTAMP
+                                        WHERE id = ? AND deleted_at IS NULL`, id)
+	if err != nil {
+		log.Printf("Failing trashing book %v: %s\n", id, err.Error())
+		return err
+	}
+	return checkAffected(res)
 }
 
-func (sq *SQLiteDB) sortAndLimit(baseQuery string) (query string) {
-	const limitStmt string = `ORDER BY id LIMIT ?`
-	query = fmt.Sprintf("%s %s", baseQuery, limitStmt)
-	return
+// RestoreBook takes the book with the given id out of the trash, returning
+// ErrNotFound when it isn't there.
+func (sq *SQLiteDB) RestoreBook(ctx context.Context, id float64) (*models.Book, error) {
+	res, err := sq.db.ExecContext(ctx, `UPDATE book SET deleted_at = NULL
+                                        WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		log.Printf("Failing restoring book %v: %s\n", id, err.Error())
+		return nil, err
+	}
+	if err = checkAffected(res); err != nil {
+		return nil, err
+	}
+	return sq.FetchBook(ctx, id)
+}
+
+// PurgeBooks permanently removes the books trashed before the given time and
+// returns how many were removed.
+func (sq *SQLiteDB) PurgeBooks(ctx context.Context, before time.Time) (int, error) {
+	res, err := sq.db.ExecContext(ctx, `DELETE FROM book WHERE deleted_at < ?`,
+		before.UTC().Format(sqliteTimestamp))
+	if err != nil {
+		log.Printf("Failing purging books: %s\n", err.Error())
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	return int(purged), err
+}
+
+// checkAffected returns ErrNotFound when the statement changed no rows.
+func checkAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// addBookAuthors relates the given authors to the book, skipping the ones
+// already related.
+func (sq *SQLiteDB) addBookAuthors(ctx context.Context, tx *sql.Tx, bookId float64, authors []float64) error {
+	if len(authors) == 0 {
+		return nil
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO author_book (author_id, book_id)
+                                         SELECT ?, ? WHERE NOT EXISTS (
+                                             SELECT 1 FROM author_book WHERE author_id = ? AND book_id = ?)`)
+	if err != nil {
+		log.Printf("Failing preraring new author_book statement:%s\n", err.Error())
+		return err
+	}
+	defer stmt.Close()
+	for _, author := range authors {
+		_, err = stmt.ExecContext(ctx, author, bookId, author, bookId)
+		if err != nil {
+			log.Printf("Failing inserting author_book relationship with author_id: %v, book_id: %v.\n%s", author, bookId, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchAuthorIdsByName returns the id of the authors matching the given names
+// once normalized, keyed by the normalized name. Unknown names are left out.
+func (sq *SQLiteDB) FetchAuthorIdsByName(ctx context.Context, names []string) (map[string]float64, error) {
+	ids := map[string]float64{}
+	keys := make([]any, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, models.NormalizeAuthorName(name))
+	}
+	err := sq.queryInChunks(ctx, `SELECT id, name_key FROM author WHERE name_key IN`, keys, func(rows *sql.Rows) error {
+		var id float64
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			return err
+		}
+		ids[key] = id
+		return nil
+	})
+	return ids, err
+}
+
+// FetchMissingAuthorIds returns the given author ids not stored in the
+// database.
+func (sq *SQLiteDB) FetchMissingAuthorIds(ctx context.Context, authorIds []float64) ([]float64, error) {
+	found := map[float64]bool{}
+	ids := make([]any, 0, len(authorIds))
+	for _, id := range authorIds {
+		ids = append(ids, id)
+	}
+	err := sq.queryInChunks(ctx, `SELECT id FROM author WHERE id IN`, ids, func(rows *sql.Rows) error {
+		var id float64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		found[id] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []float64{}
+	for _, id := range authorIds {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
 }
 
-func (sq *SQLiteDB) applyQueryParams(baseQuery string, q allowedQParams, params url.Values) (query string, paramVals []any) {
+// maxQueryVars keeps the IN lists below the SQLite variables limit.
+const maxQueryVars = 500
+
+// queryInChunks runs query, ending in an IN operator, for every chunk of vals
+// and calls scan for every returned row.
+func (sq *SQLiteDB) queryInChunks(ctx context.Context, query string, vals []any, scan func(*sql.Rows) error) error {
+	for len(vals) > 0 {
+		chunk := vals
+		if len(chunk) > maxQueryVars {
+			chunk = chunk[:maxQueryVars]
+		}
+		vals = vals[len(chunk):]
+
+		rows, err := sq.db.QueryContext(ctx, fmt.Sprintf("%s %s", query, placeholders(len(chunk))), chunk...)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		for rows.Next() {
+			if err = scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeholders returns a parenthesized list of n query placeholders.
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+// sortAndLimit appends the keyset condition of the pagination cursor, the
+// ordering of the sort fields and the limit, returning the values of the
+// keyset placeholders. The rows before a cursor are read backwards, so the
+// closest ones to the cursor come first.
+func (sq *SQLiteDB) sortAndLimit(baseQuery string, sorts []sortField, pagination *m.PaginationVals) (query string, keysetVals []any, err error) {
 	query = baseQuery
-	for key, fun := range q.params {
-		if params.Has(key) {
-			query = fun(query)
-			keyVal := params.Get(key)
-			paramVals = append(paramVals, keyVal)
+	prev := false
+	if c := pagination.Cursor; c != nil {
+		values, err := cursorValues(sorts, c)
+		if err != nil {
+			return baseQuery, nil, err
 		}
+		var cond string
+		cond, keysetVals = keysetCond(sorts, values, c.Prev)
+		query = fmt.Sprintf("%s AND %s", query, cond)
+		prev = c.Prev
+	}
+	query = fmt.Sprintf("%s %s LIMIT ?", query, orderBy(sorts, prev))
+	return
+}
+
+// applyQueryParams appends to baseQuery the conditions of the filter params,
+// returning the values of their placeholders in order.
+func (sq *SQLiteDB) applyQueryParams(baseQuery string, q allowedQParams, params url.Values) (query string, paramVals []any, err error) {
+	conds, err := parseFilters(q.params, params)
+	if err != nil {
+		return baseQuery, nil, err
+	}
+	query = baseQuery
+	for _, cond := range conds {
+		query = fmt.Sprintf("%s AND %s", query, cond.sql(q.params[cond.field]))
+		paramVals = append(paramVals, cond.values...)
 	}
 	return
 }
 
-func (sq *SQLiteDB) applySortAndLimit(baseQuery string, pageId int, limit int, paramVals []any) (query string, queryVals []any) {
-	query = sq.sortAndLimit(baseQuery)
-	queryVals = []any{pageId}
+func (sq *SQLiteDB) applySortAndLimit(baseQuery string, sorts []sortField, pagination *m.PaginationVals, paramVals []any) (query string, queryVals []any, err error) {
+	query, keysetVals, err := sq.sortAndLimit(baseQuery, sorts, pagination)
+	if err != nil {
+		return baseQuery, nil, err
+	}
 	queryVals = append(queryVals, paramVals...)
-	queryVals = append(queryVals, limit)
+	queryVals = append(queryVals, keysetVals...)
+	queryVals = append(queryVals, pagination.Limit)
 	return
 }
 
@@ -286,27 +894,69 @@ func (sq *SQLiteDB) FetchAuthorsForBooks(books []*models.Book) ([]*models.Book,
 }
 
 func (sq *SQLiteDB) FetchBooks(pagination *m.PaginationVals, params url.Values) ([]*models.Book, error) {
-	const nameKey string = "name"
-	const pubYearKey string = "publication_year"
-	const editionKey string = "edition"
-	const authorKey string = "author"
+	return sq.fetchBooks(pagination, params, false)
+}
+
+// FetchTrashedBooks lists the soft deleted books with the same filters and
+// pagination of FetchBooks.
+func (sq *SQLiteDB) FetchTrashedBooks(pagination *m.PaginationVals, params url.Values) ([]*models.Book, error) {
+	return sq.fetchBooks(pagination, params, true)
+}
+
+func (sq *SQLiteDB) CountBooks(params url.Values) (int, error) {
+	return sq.countRows("book", "deleted_at IS NULL", allowedQParams{params: bookFilters}, params)
+}
+
+func (sq *SQLiteDB) CountTrashedBooks(params url.Values) (int, error) {
+	return sq.countRows("book", "deleted_at IS NOT NULL", allowedQParams{params: bookFilters}, params)
+}
+
+func (sq *SQLiteDB) CountAuthors(params url.Values) (int, error) {
+	return sq.countRows("author", "1 = 1", allowedQParams{params: authorFilters}, params)
+}
+
+// countRows counts the rows of table matching where and the filter params.
+// Unlike the lists, the count query isn't sorted nor joined with the authors,
+// so SQLite can answer it from the indexes when the filters allow it.
+func (sq *SQLiteDB) countRows(table string, where string, q allowedQParams, params url.Values) (int, error) {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", table, where)
+	query, paramVals, err := sq.applyQueryParams(query, q, params)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err = sq.db.QueryRow(query, paramVals...).Scan(&count); err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (sq *SQLiteDB) fetchBooks(pagination *m.PaginationVals, params url.Values, trashed bool) ([]*models.Book, error) {
 	var books = []*models.Book{}
 	var rows *sql.Rows
 	var err error
-	pageId := pagination.PageId
-	limit := pagination.Limit
-
-	query := `SELECT id, name, edition, publication_year FROM book
-              WHERE id > ?`
+	query := `SELECT id, name, edition, publication_year, deleted_at FROM book
+              WHERE deleted_at IS NULL`
+	if trashed {
+		query = `SELECT id, name, edition, publication_year, deleted_at FROM book
+                 WHERE deleted_at IS NOT NULL`
+	}
 
-	allowedParams := allowedQParams{
-		params: map[string]func(string) string{
-			nameKey: sq.filterByName,
-		},
+	allowedParams := allowedQParams{params: bookFilters}
+	sorts, err := parseSort(bookSorts, params.Get(SortKey))
+	if err != nil {
+		return books, err
 	}
 
-	query, paramVals := sq.applyQueryParams(query, allowedParams, params)
-	query, queryVals := sq.applySortAndLimit(query, pageId, limit, paramVals)
+	query, paramVals, err := sq.applyQueryParams(query, allowedParams, params)
+	if err != nil {
+		return books, err
+	}
+	query, queryVals, err := sq.applySortAndLimit(query, sorts, pagination, paramVals)
+	if err != nil {
+		return books, err
+	}
 	rows, err = sq.execQuery(query, queryVals...)
 	if err != nil {
 		return books, err
@@ -319,13 +969,21 @@ func (sq *SQLiteDB) FetchBooks(pagination *m.PaginationVals, params url.Values)
 		var name string
 		var edition float64
 		var pubYear float64
+		var deletedAt sql.NullTime
 
-		err = rows.Scan(&id, &name, &edition, &pubYear)
+		err = rows.Scan(&id, &name, &edition, &pubYear, &deletedAt)
 		if err != nil {
 			return books, err
 		}
 
-		books = append(books, models.NewBook(id, name, edition, pubYear, []float64{}))
+		book := models.NewBook(id, name, edition, pubYear, []float64{})
+		if deletedAt.Valid {
+			book.DeletedAt = &deletedAt.Time
+		}
+		books = append(books, book)
+	}
+	if pagination.Cursor != nil && pagination.Cursor.Prev {
+		slices.Reverse(books)
 	}
 
 	if len(books) > 0 {
@@ -339,25 +997,54 @@ func (sq *SQLiteDB) FetchBooks(pagination *m.PaginationVals, params url.Values)
 	return books, err
 }
 
+// FetchBook returns the book with the given id along with its authors ids, or
+// ErrNotFound when it doesn't exist.
+func (sq *SQLiteDB) FetchBook(ctx context.Context, id float64) (*models.Book, error) {
+	query := `SELECT id, name, edition, publication_year FROM book
+              WHERE id = ? AND deleted_at IS NULL`
+
+	var bookId float64
+	var name string
+	var edition float64
+	var pubYear float64
+	err := sq.db.QueryRowContext(ctx, query, id).Scan(&bookId, &name, &edition, &pubYear)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	books, err := sq.FetchAuthorsForBooks([]*models.Book{models.NewBook(bookId, name, edition, pubYear, []float64{})})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return books[0], nil
+}
+
 func (sq *SQLiteDB) FetchAuthors(pagination *m.PaginationVals, params url.Values) ([]*models.Author, error) {
-	const nameKey string = "name"
 	var authors = []*models.Author{}
 	var rows *sql.Rows
 	var err error
-	pageId := pagination.PageId
-	limit := pagination.Limit
-
-	query := `SELECT id, name FROM author
-              WHERE id > ?`
+	query := `SELECT id, name, birth_year, nationality, external_id, name_key FROM author
+              WHERE 1 = 1`
 
-	allowedParams := allowedQParams{
-		params: map[string]func(string) string{
-			nameKey: sq.filterByName,
-		},
+	allowedParams := allowedQParams{params: authorFilters}
+	sorts, err := parseSort(authorSorts, params.Get(SortKey))
+	if err != nil {
+		return authors, err
 	}
 
-	query, paramVals := sq.applyQueryParams(query, allowedParams, params)
-	query, queryVals := sq.applySortAndLimit(query, pageId, limit, paramVals)
+	query, paramVals, err := sq.applyQueryParams(query, allowedParams, params)
+	if err != nil {
+		return authors, err
+	}
+	query, queryVals, err := sq.applySortAndLimit(query, sorts, pagination, paramVals)
+	if err != nil {
+		return authors, err
+	}
 	rows, err = sq.execQuery(query, queryVals...)
 	if err != nil {
 		return authors, err
@@ -368,13 +1055,34 @@ func (sq *SQLiteDB) FetchAuthors(pagination *m.PaginationVals, params url.Values
 	for rows.Next() {
 		var id uint64
 		var name string
+		var birthYear sql.NullInt64
+		var nationality sql.NullString
+		var externalId sql.NullString
+		var nameKey sql.NullString
 
-		err = rows.Scan(&id, &name)
+		err = rows.Scan(&id, &name, &birthYear, &nationality, &externalId, &nameKey)
 		if err != nil {
 			return authors, err
 		}
 
-		authors = append(authors, models.NewAuthor(id, name))
+		author := models.NewAuthor(id, name)
+		if birthYear.Valid {
+			year := int(birthYear.Int64)
+			author.BirthYear = &year
+		}
+		if nationality.Valid {
+			author.Nationality = &nationality.String
+		}
+		if externalId.Valid {
+			author.ExternalId = &externalId.String
+		}
+		if nameKey.Valid {
+			author.NameKey = &nameKey.String
+		}
+		authors = append(authors, author)
+	}
+	if pagination.Cursor != nil && pagination.Cursor.Prev {
+		slices.Reverse(authors)
 	}
 
 	return authors, nil
@@ -0,0 +1,523 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	m "github.com/jcardenasc93/work-at-olist/app/middlewares"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+func TestResolveName(t *testing.T) {
+	t.Setenv(DBNameEnv, "env.db")
+	if name, err := ResolveName("flag.db"); err != nil || name != "flag.db" {
+		t.Errorf("Expected the -db value to win but got %q, %v", name, err)
+	}
+	if name, err := ResolveName(""); err != nil || name != "env.db" {
+		t.Errorf("Expected $%s as fallback but got %q, %v", DBNameEnv, name, err)
+	}
+	t.Setenv(DBNameEnv, "")
+	if _, err := ResolveName(""); err == nil {
+		t.Error("Expected an error without -db nor $" + DBNameEnv)
+	}
+}
+
+func TestOpenSQLiteDB(t *testing.T) {
+	if _, err := OpenSQLiteDB(""); err == nil {
+		t.Error("Expected an error for an empty database name")
+	}
+	sq, err := OpenSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sq.db.Close()
+	if err = sq.CreateAuthorTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = sq.InsertAuthor("Machado de Assis"); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err = sq.db.QueryRow(`SELECT COUNT(*) FROM author`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("Expected the author to be stored but got %d, %v", count, err)
+	}
+}
+
+// newAuthorsDB returns a database with the author tables where inserting an
+// author named boom fails.
+func newAuthorsDB(t *testing.T) *SQLiteDB {
+	t.Helper()
+	sq, err := OpenSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sq.db.Close() })
+	if err = sq.CreateAuthorTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = sq.CreateImportCheckpointTable(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sq.db.Exec(`CREATE TRIGGER author_boom BEFORE INSERT ON author
+                         WHEN NEW.name = 'boom' BEGIN SELECT RAISE(ABORT, 'boom'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sq
+}
+
+func authorsNamed(names ...string) []*models.Author {
+	authors := make([]*models.Author, len(names))
+	for i, name := range names {
+		authors[i] = &models.Author{Name: name}
+	}
+	return authors
+}
+
+func TestSQLiteInsertAuthors(t *testing.T) {
+	sq := newAuthorsDB(t)
+	var err error
+
+	ctx := context.Background()
+	if _, err = sq.InsertAuthors(ctx, authorsNamed("Homer", "Virgil"), DuplicateSkip, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sq.InsertAuthors(ctx, authorsNamed("Ovid", "boom"), DuplicateSkip, nil); err == nil {
+		t.Error("Expected the batch with a failing row to fail")
+	}
+	if _, err = sq.InsertAuthors(ctx, authorsNamed("Dante"), DuplicateSkip, nil); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err = sq.db.QueryRow(`SELECT COUNT(*) FROM author`).Scan(&count); err != nil || count != 3 {
+		t.Errorf("Expected the failed batch to be rolled back leaving 3 authors but got %d, %v", count, err)
+	}
+}
+
+func TestSQLiteInsertAuthorsDuplicates(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		onDup   OnDuplicate
+		names   []*models.Author
+		fails   bool
+		matched int
+		name    string
+	}{
+		{DuplicateSkip, authorsNamed("Homer", "Virgil"), false, 1, "HOMER "},
+		{DuplicateKeep, authorsNamed("Homer", "Virgil"), false, 1, "Homer"},
+		{DuplicateError, authorsNamed("Virgil", "Homer"), true, 1, "HOMER "},
+	}
+	for _, c := range cases {
+		sq := newAuthorsDB(t)
+		if _, err := sq.InsertAuthors(ctx, authorsNamed("HOMER "), DuplicateError, nil); err != nil {
+			t.Fatal(err)
+		}
+		matched, err := sq.InsertAuthors(ctx, c.names, c.onDup, nil)
+		if (err != nil) != c.fails || matched != c.matched {
+			t.Errorf("Expected %d match and failure %v on %s but got %d, %v", c.matched, c.fails, c.onDup, matched, err)
+		}
+		if c.fails && !errors.Is(err, ErrDuplicateAuthor) {
+			t.Errorf("Expected ErrDuplicateAuthor but got %v", err)
+		}
+		var name string
+		if err = sq.db.QueryRow(`SELECT name FROM author WHERE id = 1`).Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if name != c.name {
+			t.Errorf("Expected the existing author named %q on %s but got %q", c.name, c.onDup, name)
+		}
+	}
+}
+
+func TestSQLiteMigrateAuthorNameKey(t *testing.T) {
+	sq, err := OpenSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sq.db.Close()
+	_, err = sq.db.Exec(`CREATE TABLE author (id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(64) NOT NULL);
+                         INSERT INTO author (name) VALUES ('Homer'), ('homer'), ('Virgil')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sq.CreateAuthorTable(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := sq.db.Query(`SELECT name_key FROM author ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var keys []*string
+	for rows.Next() {
+		var key *string
+		if err = rows.Scan(&key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) != 3 || keys[0] == nil || *keys[0] != "homer" || keys[1] != nil || keys[2] == nil {
+		t.Errorf("Expected only the first of the duplicated authors to get a key but got %v", keys)
+	}
+	if matched, err := sq.InsertAuthors(context.Background(), authorsNamed("HOMER"), DuplicateSkip, nil); err != nil || matched != 1 {
+		t.Errorf("Expected the migrated authors to be matched but got %d, %v", matched, err)
+	}
+}
+
+func TestSQLiteImportCheckpoint(t *testing.T) {
+	sq := newAuthorsDB(t)
+	ctx := context.Background()
+	if _, err := sq.FetchImportCheckpoint(ctx, "authors.csv"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound before the first batch but got %v", err)
+	}
+
+	cp := &ImportCheckpoint{Name: "authors.csv", InputHash: "sha256:1", Offset: 30, Read: 4, Skipped: 1}
+	if _, err := sq.InsertAuthors(ctx, authorsNamed("Homer", "Virgil", "homer"), DuplicateSkip, cp); err != nil {
+		t.Fatal(err)
+	}
+	failed := &ImportCheckpoint{Name: "authors.csv", InputHash: "sha256:1", Offset: 50, Read: 6, Skipped: 1, Imported: 2, Duplicated: 1}
+	if _, err := sq.InsertAuthors(ctx, authorsNamed("Ovid", "boom"), DuplicateSkip, failed); err == nil {
+		t.Fatal("Expected the batch with a failing row to fail")
+	}
+
+	// The checkpoint of the failed batch is rolled back with its authors
+	saved, err := sq.FetchImportCheckpoint(ctx, "authors.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ImportCheckpoint{Name: "authors.csv", InputHash: "sha256:1", Offset: 30, Read: 4, Skipped: 1, Imported: 2, Duplicated: 1}
+	if *saved != expected {
+		t.Errorf("Expected the checkpoint %+v but got %+v", expected, *saved)
+	}
+
+	if err = sq.DeleteImportCheckpoint(ctx, "authors.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sq.FetchImportCheckpoint(ctx, "authors.csv"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after deleting the checkpoint but got %v", err)
+	}
+}
+
+// newTestDB returns a SQLite database in a temporary file with the authors
+// and books given, the books written by the authors at the listed positions.
+func newTestDB(t *testing.T, authors []string, books []*models.CreateBookReq) *SQLiteDB {
+	t.Helper()
+	sq, err := OpenSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sq.db.Close() })
+	if err = sq.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err = sq.InsertAuthors(ctx, authorsNamed(authors...), DuplicateError, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(books) > 0 {
+		if _, err = sq.InsertBooks(ctx, books); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sq
+}
+
+func TestSQLitePurgeBooks(t *testing.T) {
+	books := []*models.CreateBookReq{}
+	for _, name := range []string{"Live", "Old", "Recent", "Older"} {
+		books = append(books, &models.CreateBookReq{Name: name, Edition: 1, PubYear: 2000, Authors: []float64{1}})
+	}
+	sq := newTestDB(t, []string{"Homer"}, books)
+	ctx := context.Background()
+	for id := 2; id <= 4; id++ {
+		if err := sq.TrashBook(ctx, float64(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now().UTC()
+	trashedAt := map[int]time.Time{2: now.AddDate(0, 0, -40), 3: now.AddDate(0, 0, -2), 4: now.AddDate(-1, 0, 0)}
+	for id, at := range trashedAt {
+		if _, err := sq.db.Exec(`UPDATE book SET deleted_at = ? WHERE id = ?`, at.Format(sqliteTimestamp), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := sq.PurgeBooks(ctx, now.AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Errorf("Expected the 2 books trashed over 30 days ago to be purged but got %d", purged)
+	}
+	var ids []float64
+	rows, err := sq.db.Query(`SELECT id FROM book ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id float64
+		if err = rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Errorf("Expected the live and the recently trashed books to be kept but got %v", ids)
+	}
+	var links int
+	if err = sq.db.QueryRow(`SELECT COUNT(*) FROM author_book`).Scan(&links); err != nil {
+		t.Fatal(err)
+	}
+	if links != 2 {
+		t.Errorf("Expected the authors of the purged books to be unlinked but got %d links", links)
+	}
+
+	if purged, err = sq.PurgeBooks(ctx, now.AddDate(0, 0, -30)); err != nil || purged != 0 {
+		t.Errorf("Expected nothing left to purge but got %d, %v", purged, err)
+	}
+}
+
+// newCatalogDB returns a database with a few books sharing names, editions
+// and years, so the filters and sorts have ties to break.
+func newCatalogDB(t *testing.T) *SQLiteDB {
+	t.Helper()
+	book := func(name string, edition float64, pubYear float64, authors ...float64) *models.CreateBookReq {
+		return &models.CreateBookReq{Name: name, Edition: edition, PubYear: pubYear, Authors: authors}
+	}
+	return newTestDB(t, []string{"Luciano Ramalho", "Mark  Lutz", "Homer"}, []*models.CreateBookReq{
+		book("Fluent Python", 1, 2015, 1),
+		book("Fluent Python", 2, 2022, 1),
+		book("Learning Python", 5, 2013, 2),
+		book("Programming Python", 4, 2010, 2, 1),
+		book("Odyssey", 1, 1999, 3),
+		book("Iliad", 2, 1999, 3),
+		book("Python Pocket Reference", 5, 2014, 2),
+	})
+}
+
+func bookIds(books []*models.Book) []float64 {
+	ids := []float64{}
+	for _, book := range books {
+		ids = append(ids, book.Id)
+	}
+	return ids
+}
+
+func TestSQLiteBookFilters(t *testing.T) {
+	sq := newCatalogDB(t)
+	cases := []struct {
+		query string
+		ids   []float64
+	}{
+		{"", []float64{1, 2, 3, 4, 5, 6, 7}},
+		{"name=PYTHON", []float64{1, 2, 3, 4, 7}},
+		{"name!=python", []float64{5, 6}},
+		{"edition=1", []float64{1, 5}},
+		{"edition=1,2", []float64{1, 2, 5, 6}},
+		{"edition!=1,2", []float64{3, 4, 7}},
+		{"edition[nin]=5", []float64{1, 2, 4, 5, 6}},
+		{"edition[ne]=5", []float64{1, 2, 4, 5, 6}},
+		{"publication_year=1999", []float64{5, 6}},
+		{"publication_year[gte]=2010&publication_year[lt]=2015", []float64{3, 4, 7}},
+		{"publication_year[gt]=2014&publication_year[lte]=2022", []float64{1, 2}},
+		{"author=2", []float64{3, 4, 7}},
+		{"author=1,3", []float64{1, 2, 4, 5, 6}},
+		{"author!=1", []float64{3, 5, 6, 7}},
+		{"author[nin]=1,2", []float64{5, 6}},
+		{"author_name=lutz", []float64{3, 4, 7}},
+		{"author_name= MARK   LUTZ ", []float64{3, 4, 7}},
+		{"author_name!=ramalho", []float64{3, 5, 6, 7}},
+		{"name=python&edition=5", []float64{3, 7}},
+		{"name=python&name!=fluent", []float64{3, 4, 7}},
+		{"author=3&publication_year=1999&edition=2", []float64{6}},
+		{"edition=3", []float64{}},
+	}
+	for _, c := range cases {
+		params, _ := url.ParseQuery(c.query)
+		books, err := sq.FetchBooks(&m.PaginationVals{Limit: 100}, params)
+		if err != nil {
+			t.Errorf("Expected %q to be applied but got %v", c.query, err)
+			continue
+		}
+		if ids := bookIds(books); !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("Expected books %v for %q but got %v", c.ids, c.query, ids)
+		}
+		count, err := sq.CountBooks(params)
+		if err != nil || count != len(c.ids) {
+			t.Errorf("Expected a count of %d for %q but got %d, %v", len(c.ids), c.query, count, err)
+		}
+	}
+
+	for _, query := range []string{"edition=first", "edition=1,x", "name[gt]=a", "author[gte]=1", "author_name[eq]=homer"} {
+		params, _ := url.ParseQuery(query)
+		if _, err := sq.FetchBooks(&m.PaginationVals{Limit: 100}, params); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Expected ErrInvalidFilter for %q but got %v", query, err)
+		}
+		if _, err := sq.CountBooks(params); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Expected ErrInvalidFilter counting %q but got %v", query, err)
+		}
+	}
+}
+
+func TestSQLiteCounts(t *testing.T) {
+	sq := newCatalogDB(t)
+	ctx := context.Background()
+	for _, id := range []float64{3, 5} {
+		if err := sq.TrashBook(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		query   string
+		books   int
+		trashed int
+		authors int
+	}{
+		{"", 5, 2, 3},
+		{"name=python", 4, 1, 0},
+		{"author_name=homer", 1, 1, 0},
+		{"name=lu", 2, 0, 2},
+	}
+	for _, c := range cases {
+		params, _ := url.ParseQuery(c.query)
+		books, err := sq.CountBooks(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trashed, err := sq.CountTrashedBooks(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if books != c.books || trashed != c.trashed {
+			t.Errorf("Expected %d books and %d trashed for %q but got %d and %d", c.books, c.trashed, c.query, books, trashed)
+		}
+		if c.authors == 0 {
+			continue
+		}
+		authors, err := sq.CountAuthors(params)
+		if err != nil || authors != c.authors {
+			t.Errorf("Expected %d authors for %q but got %d, %v", c.authors, c.query, authors, err)
+		}
+		list, err := sq.FetchAuthors(&m.PaginationVals{Limit: 100}, params)
+		if err != nil || len(list) != c.authors {
+			t.Errorf("Expected %d authors listed for %q but got %d, %v", c.authors, c.query, len(list), err)
+		}
+	}
+}
+
+// pageBooks walks the books of query limit rows at a time, forwards from the
+// start and then backwards from the last row, as the API cursors do.
+func pageBooks(t *testing.T, sq *SQLiteDB, query string, limit int) (forward []float64, backward []float64) {
+	t.Helper()
+	params, _ := url.ParseQuery(query)
+	sort := params.Get(SortKey)
+	cursor := func(book *models.Book, prev bool) *m.Cursor {
+		return &m.Cursor{Sort: sort, Key: BookCursorKey(sort, book), Id: book.Id, Prev: prev}
+	}
+
+	var last *models.Book
+	pagination := &m.PaginationVals{Limit: limit}
+	for {
+		books, err := sq.FetchBooks(pagination, params)
+		if err != nil {
+			t.Fatalf("Expected %q to be paged but got %v", query, err)
+		}
+		forward = append(forward, bookIds(books)...)
+		if len(books) < limit {
+			break
+		}
+		last = books[len(books)-1]
+		pagination = &m.PaginationVals{Limit: limit, Cursor: cursor(last, false)}
+	}
+	if len(forward) == 0 {
+		return forward, forward
+	}
+
+	// The last row is only known once the pages run out
+	last, err := sq.FetchBook(context.Background(), forward[len(forward)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	backward = []float64{last.Id}
+	pagination = &m.PaginationVals{Limit: limit, Cursor: cursor(last, true)}
+	for {
+		books, err := sq.FetchBooks(pagination, params)
+		if err != nil {
+			t.Fatalf("Expected %q to be paged backwards but got %v", query, err)
+		}
+		backward = append(bookIds(books), backward...)
+		if len(books) < limit {
+			break
+		}
+		pagination = &m.PaginationVals{Limit: limit, Cursor: cursor(books[0], true)}
+	}
+	return forward, backward
+}
+
+func TestSQLiteSortedPaging(t *testing.T) {
+	sq := newCatalogDB(t)
+	cases := []struct {
+		query string
+		ids   []float64
+	}{
+		{"", []float64{1, 2, 3, 4, 5, 6, 7}},
+		{"sort=-id", []float64{7, 6, 5, 4, 3, 2, 1}},
+		{"sort=-publication_year,name", []float64{2, 1, 7, 3, 4, 6, 5}},
+		{"sort=name,-edition", []float64{2, 1, 6, 3, 5, 4, 7}},
+		{"sort=-edition", []float64{3, 7, 4, 2, 6, 1, 5}},
+		{"sort=publication_year,-id", []float64{6, 5, 4, 3, 7, 1, 2}},
+		{"sort=-edition&name=python", []float64{3, 7, 4, 2, 1}},
+	}
+	for _, c := range cases {
+		for _, limit := range []int{1, 2, 3} {
+			forward, backward := pageBooks(t, sq, c.query, limit)
+			if !reflect.DeepEqual(forward, c.ids) {
+				t.Errorf("Expected books %v for %q by %d but got %v", c.ids, c.query, limit, forward)
+			}
+			if !reflect.DeepEqual(backward, c.ids) {
+				t.Errorf("Expected books %v backwards for %q by %d but got %v", c.ids, c.query, limit, backward)
+			}
+		}
+	}
+
+	for _, query := range []string{"sort=isbn", "sort=name,name", "sort=-"} {
+		params, _ := url.ParseQuery(query)
+		if _, err := sq.FetchBooks(&m.PaginationVals{Limit: 10}, params); !errors.Is(err, ErrInvalidSort) {
+			t.Errorf("Expected ErrInvalidSort for %q but got %v", query, err)
+		}
+	}
+	// A cursor made for another sort has a different number of values
+	params, _ := url.ParseQuery("sort=-publication_year,name")
+	stale := &m.Cursor{Sort: "name", Key: []any{"Iliad"}, Id: 6}
+	if _, err := sq.FetchBooks(&m.PaginationVals{Limit: 10, Cursor: stale}, params); !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("Expected ErrInvalidSort for a cursor of another sort but got %v", err)
+	}
+}
+
+func TestSQLiteSortedAuthors(t *testing.T) {
+	sq := newCatalogDB(t)
+	params, _ := url.ParseQuery("sort=-name")
+	authors := []uint64{}
+	pagination := &m.PaginationVals{Limit: 1}
+	for {
+		page, err := sq.FetchAuthors(pagination, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		last := page[len(page)-1]
+		authors = append(authors, last.Id)
+		pagination = &m.PaginationVals{Limit: 1, Cursor: &m.Cursor{Sort: "-name", Key: AuthorCursorKey("-name", last), Id: float64(last.Id)}}
+	}
+	if !reflect.DeepEqual(authors, []uint64{2, 1, 3}) {
+		t.Errorf("Expected the authors by name descending but got %v", authors)
+	}
+}
@@ -2,26 +2,83 @@ package db
 
 import (
 	"context"
+	"errors"
 	"net/url"
+	"time"
 
 	"github.com/jcardenasc93/work-at-olist/app/middlewares"
 	"github.com/jcardenasc93/work-at-olist/app/models"
 )
 
 type allowedQParams struct {
-	params map[string]func(string) string
+	params map[string]filterField
+}
+
+// ErrNotFound is returned when the requested record doesn't exist.
+var ErrNotFound = errors.New("record not found")
+
+// OnDuplicate tells InsertAuthors what to do with a name matching the
+// normalized name of an existing author.
+type OnDuplicate string
+
+const (
+	// DuplicateSkip ignores the incoming name.
+	DuplicateSkip OnDuplicate = "skip"
+	// DuplicateKeep keeps the existing author id but stores the incoming name.
+	DuplicateKeep OnDuplicate = "keep"
+	// DuplicateError aborts the insertion with ErrDuplicateAuthor.
+	DuplicateError OnDuplicate = "error"
+)
+
+var ErrDuplicateAuthor = errors.New("author already exists")
+
+// ErrNoAuthors is returned when an update would leave a book without authors.
+var ErrNoAuthors = errors.New("book must have at least one author")
+
+// ImportCheckpoint is the progress of a file import. InsertAuthors saves it
+// in the transaction of the batch it follows, so the stored rows and the
+// recorded progress can't disagree after a crash.
+type ImportCheckpoint struct {
+	// Name identifies the import.
+	Name      string
+	InputHash string
+	// Offset is the input position after the last committed row.
+	Offset int64
+	// Counters of the rows up to Offset. InsertAuthors adds the authors of
+	// its batch to Imported and Duplicated before saving them.
+	Read       int
+	Skipped    int
+	Imported   int
+	Duplicated int
 }
 
 type ApiDB interface {
 	Setup() error
 	CreateAuthorTable() error
 	InsertAuthor(string) error
+	InsertAuthors(context.Context, []*models.Author, OnDuplicate, *ImportCheckpoint) (int, error)
+	CreateImportCheckpointTable() error
+	FetchImportCheckpoint(context.Context, string) (*ImportCheckpoint, error)
+	DeleteImportCheckpoint(context.Context, string) error
 	FetchAuthors(*middlewares.PaginationVals, url.Values) ([]*models.Author, error)
+	CountAuthors(url.Values) (int, error)
 	FetchBooks(*middlewares.PaginationVals, url.Values) ([]*models.Book, error)
+	CountBooks(url.Values) (int, error)
+	FetchBook(context.Context, float64) (*models.Book, error)
 	FetchAuthorsForBooks([]*models.Book) ([]*models.Book, error)
-	applyQueryParams(string, allowedQParams, url.Values) (string, []any)
-	sortAndLimit(string) string
+	applyQueryParams(string, allowedQParams, url.Values) (string, []any, error)
+	sortAndLimit(string, []sortField, *middlewares.PaginationVals) (string, []any, error)
 	CreateBookTable() error
 	CreateAuthorBookTable() error
 	InsertBook(context.Context, *models.CreateBookReq) (*models.Book, error)
+	InsertBooks(context.Context, []*models.CreateBookReq) ([]*models.Book, error)
+	UpdateBook(context.Context, float64, *models.UpdateBookReq) (*models.Book, error)
+	DeleteBook(context.Context, float64) error
+	TrashBook(context.Context, float64) error
+	RestoreBook(context.Context, float64) (*models.Book, error)
+	FetchTrashedBooks(*middlewares.PaginationVals, url.Values) ([]*models.Book, error)
+	CountTrashedBooks(url.Values) (int, error)
+	PurgeBooks(context.Context, time.Time) (int, error)
+	FetchAuthorIdsByName(context.Context, []string) (map[string]float64, error)
+	FetchMissingAuthorIds(context.Context, []float64) ([]float64, error)
 }
@@ -0,0 +1,18 @@
+package middlewares
+
+import "net/http"
+
+// DefaultMaxBodySize is the request body limit, in bytes, used when none is
+// configured.
+const DefaultMaxBodySize int64 = 1 << 20
+
+// MaxBodySize caps the request bodies to limit bytes. Reading past the limit
+// fails with an *http.MaxBytesError, so handlers can answer 413.
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,57 +2,172 @@ package middlewares
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+
+	"github.com/jcardenasc93/work-at-olist/app/render"
 )
 
-const PageIdKey = "page_id"
+const CursorKey = "cursor"
 const LimitKey = "limit"
 const DefaultLimit = 2
+const DefaultMaxLimit = 100
 const PaginationKey = "pagination"
 
+// IncludeKey is the query param asking for extra metadata in list responses,
+// a comma separated list of IncludeTotal for now.
+const IncludeKey = "include"
+const IncludeTotal = "total"
+
+// Cursor is the position of a page boundary in a keyset paginated list. It's
+// sent to clients as an opaque token, see EncodeCursor.
+type Cursor struct {
+	// Sort is the ordering the cursor was created for.
+	Sort string `json:"s"`
+	// Key holds the values of the sort fields of the boundary row, besides
+	// its id.
+	Key []any `json:"k,omitempty"`
+	// Id is the id of the boundary row, the last tie breaker of every sort.
+	Id float64 `json:"id"`
+	// Prev tells the cursor points to the rows before the boundary.
+	Prev bool `json:"p,omitempty"`
+	// Filter is the hash of the query params the cursor was created for.
+	Filter string `json:"f"`
+}
+
 type PaginationVals struct {
-	PageId int
+	// Cursor is nil on the first page.
+	Cursor *Cursor
 	Limit  int
+	// Filter is the hash of the current query params, to be carried by the
+	// cursors of the response.
+	Filter string
+	// IncludeTotal asks for the count of rows matching the filters.
+	IncludeTotal bool
+}
+
+// EncodeCursor returns the cursor as an URL safe base64 token.
+func EncodeCursor(c *Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
 }
 
+// DecodeCursor parses a token created by EncodeCursor. The key values can
+// only be strings or numbers, the types of the sortable fields.
+func DecodeCursor(token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	c := new(Cursor)
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	for _, value := range c.Key {
+		switch value.(type) {
+		case string, float64:
+		default:
+			return nil, errors.New("invalid cursor")
+		}
+	}
+	return c, nil
+}
+
+// QueryHash returns a short hash of the query params but the pagination
+// ones, so a cursor can't be reused with different filters.
+func QueryHash(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		if key != CursorKey && key != LimitKey && key != IncludeKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			h.Write([]byte(url.QueryEscape(key) + "=" + url.QueryEscape(value) + "&"))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Pagination reads the pagination params of list requests with the default
+// page sizes.
 func Pagination(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var intPageId int
-		var intLimit int
-		var err error
-
-		if r.URL.Query().Has(LimitKey) {
-			limit := r.URL.Query().Get(LimitKey)
-			intLimit, err = strconv.Atoi(limit)
-			if err != nil {
-				// TODO: Refactor errors to handle trhough ApiError
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte(err.Error()))
-				return
+	return PaginationLimits(DefaultLimit, DefaultMaxLimit)(next)
+}
+
+// PaginationLimits reads the pagination params of list requests. The limit
+// defaults to defaultLimit and must be between 1 and maxLimit.
+func PaginationLimits(defaultLimit int, maxLimit int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var intLimit int
+			var err error
+
+			if r.URL.Query().Has(LimitKey) {
+				limit := r.URL.Query().Get(LimitKey)
+				intLimit, err = strconv.Atoi(limit)
+				if err == nil && (intLimit < 1 || intLimit > maxLimit) {
+					err = fmt.Errorf("limit must be between 1 and %d", maxLimit)
+				} else if err != nil {
+					err = errors.New("limit must be an integer")
+				}
+				if err != nil {
+					render.Error(w, render.NewApiError(http.StatusBadRequest, err.Error()))
+					return
+				}
+
+			} else {
+				intLimit = defaultLimit
 			}
 
-		} else {
-			intLimit = DefaultLimit
-		}
+			includeTotal := false
+			for _, include := range r.URL.Query()[IncludeKey] {
+				for _, val := range strings.Split(include, ",") {
+					if val != IncludeTotal {
+						render.Error(w, render.NewApiError(http.StatusBadRequest, fmt.Sprintf("can't include %q", val)))
+						return
+					}
+					includeTotal = true
+				}
+			}
 
-		if r.URL.Query().Has(PageIdKey) {
-			pageId := r.URL.Query().Get(PageIdKey)
-			intPageId, err = strconv.Atoi(pageId)
-			if err != nil {
-				// TODO: Refactor errors to handle trhough ApiError
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte(err.Error()))
-				return
+			filter := QueryHash(r.URL.Query())
+			var cursor *Cursor
+			if token := r.URL.Query().Get(CursorKey); token != "" {
+				cursor, err = DecodeCursor(token)
+				if err == nil && cursor.Filter != filter {
+					err = errors.New("cursor doesn't match the current query params")
+				}
+				if err != nil {
+					render.Error(w, render.NewApiError(http.StatusBadRequest, err.Error()))
+					return
+				}
 			}
-		}
 
-		ctx := context.WithValue(r.Context(), PaginationKey, &PaginationVals{
-			Limit:  intLimit,
-			PageId: intPageId,
+			ctx := context.WithValue(r.Context(), PaginationKey, &PaginationVals{
+				Cursor:       cursor,
+				Limit:        intLimit,
+				Filter:       filter,
+				IncludeTotal: includeTotal,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	}
 }
 
 func CheckPagination(context context.Context) (p *PaginationVals, ok bool) {
@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/jcardenasc93/work-at-olist/app/render"
+)
+
+// Recoverer answers 500 with an ApiError to the requests whose handler
+// panics, logging the panic and its stack. http.ErrAbortHandler is raised
+// again, since it's meant to abort the response.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+			log.Printf("Failing serving %s %s: %v\n%s", r.Method, r.URL.Path, rvr, debug.Stack())
+			render.Error(w, render.NewApiError(http.StatusInternalServerError, "Internal Error"))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
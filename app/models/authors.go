@@ -1,8 +1,16 @@
 package models
 
+import "strings"
+
 type Author struct {
-	Id   uint64 `json:"id"`
-	Name string `json:"name"`
+	Id          uint64  `json:"id"`
+	Name        string  `json:"name"`
+	BirthYear   *int    `json:"birth_year"`
+	Nationality *string `json:"nationality"`
+	ExternalId  *string `json:"external_id"`
+	// NameKey is the stored NormalizeAuthorName of Name. It's nil for the
+	// duplicated authors of databases created before it existed.
+	NameKey *string `json:"-"`
 }
 
 func NewAuthor(id uint64, name string) *Author {
@@ -11,3 +19,9 @@ func NewAuthor(id uint64, name string) *Author {
 		Name: name,
 	}
 }
+
+// NormalizeAuthorName returns the key used to detect duplicated authors: the
+// name lower cased with its whitespace collapsed.
+func NormalizeAuthorName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
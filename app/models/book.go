@@ -1,11 +1,14 @@
 package models
 
+import "time"
+
 type Book struct {
-	Id      float64   `json:"id"`
-	Name    string    `json:"name"`
-	Edition float64   `json:"edition"`
-	PubYear float64   `json:"publication_year"`
-	Authors []float64 `json:"authors"`
+	Id        float64    `json:"id"`
+	Name      string     `json:"name"`
+	Edition   float64    `json:"edition"`
+	PubYear   float64    `json:"publication_year"`
+	Authors   []float64  `json:"authors"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 func NewBook(id float64, name string, edition float64, pubYear float64, authors []float64) *Book {
@@ -24,3 +27,31 @@ type CreateBookReq struct {
 	PubYear float64   `json:"publication_year"`
 	Authors []float64 `json:"authors"`
 }
+
+// UpdateBookReq holds the book attributes to change. Nil attributes are left
+// untouched. Authors replaces the whole authors list while AddAuthors and
+// RemoveAuthors change only the given relationships.
+type UpdateBookReq struct {
+	Name          *string    `json:"name"`
+	Edition       *float64   `json:"edition"`
+	PubYear       *float64   `json:"publication_year"`
+	Authors       *[]float64 `json:"authors"`
+	AddAuthors    []float64  `json:"add_authors"`
+	RemoveAuthors []float64  `json:"remove_authors"`
+}
+
+// ChangesAuthors tells whether the request touches the authors of the book.
+func (req *UpdateBookReq) ChangesAuthors() bool {
+	return req.Authors != nil || len(req.AddAuthors) > 0 || len(req.RemoveAuthors) > 0
+}
+
+// NewReplaceBookReq returns the update request replacing every attribute of
+// a book with the ones in req.
+func NewReplaceBookReq(req *CreateBookReq) *UpdateBookReq {
+	return &UpdateBookReq{
+		Name:    &req.Name,
+		Edition: &req.Edition,
+		PubYear: &req.PubYear,
+		Authors: &req.Authors,
+	}
+}
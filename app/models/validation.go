@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxBookNameLen matches the VARCHAR(80) size of the book.name column.
+const MaxBookNameLen = 80
+
+// Codes of the validation errors, stable so clients can switch on them.
+const (
+	CodeRequired   = "required"
+	CodeTooLong    = "too_long"
+	CodeOutOfRange = "out_of_range"
+	CodeNotInteger = "not_integer"
+	CodeConflict   = "conflict"
+	CodeDuplicate  = "duplicate"
+	CodeNotFound   = "not_found"
+	// CodeInvalidType is used when the JSON type of a value doesn't match the
+	// attribute, e.g. a string given as edition.
+	CodeInvalidType = "invalid_type"
+)
+
+// FieldError describes why the value of a request attribute is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newFieldError(field string, code string, format string, args ...any) FieldError {
+	return FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validate returns every invalid attribute of the request. present tells which
+// attributes were given in the payload, so the missing ones are reported as
+// required while zero values are reported out of range. A nil present takes
+// every attribute as given.
+func (req *CreateBookReq) Validate(present map[string]bool) []FieldError {
+	given := func(field string) bool { return present == nil || present[field] }
+	errs := []FieldError{}
+	if !given("name") {
+		errs = append(errs, newFieldError("name", CodeRequired, "name is required"))
+	} else {
+		errs = append(errs, validateBookName(req.Name)...)
+	}
+	if !given("edition") {
+		errs = append(errs, newFieldError("edition", CodeRequired, "edition is required"))
+	} else {
+		errs = append(errs, validateEdition(req.Edition)...)
+	}
+	if !given("publication_year") {
+		errs = append(errs, newFieldError("publication_year", CodeRequired, "publication_year is required"))
+	} else {
+		errs = append(errs, validatePubYear(req.PubYear)...)
+	}
+	if !given("authors") || req.Authors == nil {
+		errs = append(errs, newFieldError("authors", CodeRequired, "authors is required"))
+	}
+	return errs
+}
+
+// Validate returns every invalid attribute given in the update request.
+func (req *UpdateBookReq) Validate() []FieldError {
+	errs := []FieldError{}
+	if req.Name == nil && req.Edition == nil && req.PubYear == nil &&
+		req.Authors == nil && req.AddAuthors == nil && req.RemoveAuthors == nil {
+		return append(errs, newFieldError("", CodeRequired, "at least one attribute to update is required"))
+	}
+	if req.Name != nil {
+		errs = append(errs, validateBookName(*req.Name)...)
+	}
+	if req.Edition != nil {
+		errs = append(errs, validateEdition(*req.Edition)...)
+	}
+	if req.PubYear != nil {
+		errs = append(errs, validatePubYear(*req.PubYear)...)
+	}
+	if req.Authors != nil && (req.AddAuthors != nil || req.RemoveAuthors != nil) {
+		errs = append(errs, newFieldError("authors", CodeConflict, "authors can't be combined with add_authors or remove_authors"))
+	}
+	return errs
+}
+
+func validateBookName(name string) []FieldError {
+	if strings.TrimSpace(name) == "" {
+		return []FieldError{newFieldError("name", CodeRequired, "name can't be empty")}
+	}
+	if utf8.RuneCountInString(name) > MaxBookNameLen {
+		return []FieldError{newFieldError("name", CodeTooLong, "name can't be longer than %d characters", MaxBookNameLen)}
+	}
+	return nil
+}
+
+func validateEdition(edition float64) []FieldError {
+	if edition != math.Trunc(edition) {
+		return []FieldError{newFieldError("edition", CodeNotInteger, "edition must be an integer")}
+	}
+	if edition < 1 {
+		return []FieldError{newFieldError("edition", CodeOutOfRange, "edition must be at least 1")}
+	}
+	return nil
+}
+
+func validatePubYear(year float64) []FieldError {
+	if year != math.Trunc(year) {
+		return []FieldError{newFieldError("publication_year", CodeNotInteger, "publication_year must be an integer")}
+	}
+	if current := time.Now().Year(); year < 1 || year > float64(current) {
+		return []FieldError{newFieldError("publication_year", CodeOutOfRange, "publication_year must be between 1 and %d", current)}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package render writes the JSON responses of the API, so the controllers and
+// the middlewares answer with the same envelope.
+package render
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+// ApiError is the body of every 4xx and 5xx response.
+type ApiError struct {
+	StatusCode     int                 `json:"status_code"`
+	Msg            string              `json:"message"`
+	Errors         []models.FieldError `json:"errors,omitempty"`
+	InvalidAuthors []float64           `json:"invalid_authors,omitempty"`
+}
+
+func NewApiError(statusCode int, msg string) *ApiError {
+	return &ApiError{
+		StatusCode: statusCode,
+		Msg:        msg,
+	}
+}
+
+// JSON writes value as the JSON body of the response, or no body at all for
+// 204 responses.
+func JSON(w http.ResponseWriter, statusCode int, value any) error {
+	if statusCode == http.StatusNoContent {
+		w.WriteHeader(statusCode)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	encoder := json.NewEncoder(w)
+	// Keep the & of the query strings in the links readable
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(value)
+}
+
+// Error writes apiErr with its status code.
+func Error(w http.ResponseWriter, apiErr *ApiError) error {
+	return JSON(w, apiErr.StatusCode, apiErr)
+}
+
+// NotFound answers the requests to unknown routes.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Error(w, NewApiError(http.StatusNotFound, "Resource not found"))
+}
+
+// MethodNotAllowed answers the requests to known routes with an unsupported
+// method.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Error(w, NewApiError(http.StatusMethodNotAllowed, "Method not allowed"))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+)
+
+// checkpoint records the progress of an import. It's stored in the database
+// along with each batch, so an interrupted run can continue right after the
+// last committed batch.
+type checkpoint struct {
+	name      string
+	line      int
+	inputHash string
+	Stats     importStats
+	Offset    int64
+}
+
+func newCheckpoint(name string, inputHash string) *checkpoint {
+	return &checkpoint{
+		name:      name,
+		inputHash: inputHash,
+	}
+}
+
+// loadCheckpoint reads the checkpoint stored with the given name and checks
+// it belongs to the input identified by inputHash. It returns db.ErrNotFound
+// when there is none.
+func loadCheckpoint(ctx context.Context, store db.ApiDB, name string, inputHash string) (*checkpoint, error) {
+	stored, err := store.FetchImportCheckpoint(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if stored.InputHash != inputHash {
+		return nil, fmt.Errorf("checkpoint %s was created for a different input file", name)
+	}
+	cp := newCheckpoint(name, inputHash)
+	cp.Offset = stored.Offset
+	cp.Stats = importStats{
+		Read:       stored.Read,
+		Imported:   stored.Imported,
+		Skipped:    stored.Skipped,
+		Duplicated: stored.Duplicated,
+	}
+	return cp, nil
+}
+
+// next returns the checkpoint to save along with the batch ending at offset.
+// stats must already count the rows read for the batch, the inserted and
+// matched authors are added when saving it.
+func (cp *checkpoint) next(stats importStats, offset int64) *db.ImportCheckpoint {
+	return &db.ImportCheckpoint{
+		Name:       cp.name,
+		InputHash:  cp.inputHash,
+		Offset:     offset,
+		Read:       stats.Read,
+		Skipped:    stats.Skipped,
+		Imported:   stats.Imported,
+		Duplicated: stats.Duplicated,
+	}
+}
+
+func (cp *checkpoint) remove(ctx context.Context, store db.ApiDB) error {
+	return store.DeleteImportCheckpoint(ctx, cp.name)
+}
+
+func hashFile(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// countLines returns the number of lines in the first n bytes of file.
+func countLines(file io.Reader, n int64) (int, error) {
+	var lines int
+	buf := make([]byte, 32*1024)
+	r := io.LimitReader(file, n)
+	for {
+		c, err := r.Read(buf)
+		lines += bytes.Count(buf[:c], []byte{'\n'})
+		if errors.Is(err, io.EOF) {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Author fields that can be loaded from the input columns.
+const (
+	fieldName        = "name"
+	fieldBirthYear   = "birth_year"
+	fieldNationality = "nationality"
+	fieldExternalId  = "external_id"
+)
+
+var authorFields = []string{fieldName, fieldBirthYear, fieldNationality, fieldExternalId}
+
+const utf8BOM = "\ufeff"
+
+// columnMap is a repeatable csvcol=field flag that renames input columns to
+// author fields.
+type columnMap map[string]string
+
+func (c columnMap) String() string {
+	pairs := make([]string, 0, len(c))
+	for col, field := range c {
+		pairs = append(pairs, col+"="+field)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (c columnMap) Set(value string) error {
+	col, field, ok := strings.Cut(value, "=")
+	col = strings.TrimSpace(col)
+	field = strings.TrimSpace(field)
+	if !ok || col == "" || field == "" {
+		return fmt.Errorf("invalid mapping %q, expected csvcol=field", value)
+	}
+	if !isAuthorField(field) {
+		return fmt.Errorf("unknown author field %q, valid ones are %s", field, strings.Join(authorFields, ", "))
+	}
+	c[normalizeColumn(col)] = field
+	return nil
+}
+
+func isAuthorField(field string) bool {
+	for _, f := range authorFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeColumn(col string) string {
+	return strings.ToLower(strings.TrimSpace(col))
+}
+
+// columns holds the position of every author field in the input records.
+// Columns not mapped to a field are ignored.
+type columns struct {
+	count  int
+	fields map[string]int
+}
+
+// resolveColumns matches the header columns against the author fields, using
+// the user mappings first and the column name otherwise.
+func resolveColumns(header []string, mapping columnMap) (*columns, error) {
+	cols := &columns{
+		count:  len(header),
+		fields: map[string]int{},
+	}
+	for i, col := range header {
+		if i == 0 {
+			col = strings.TrimPrefix(col, utf8BOM)
+		}
+		col = normalizeColumn(col)
+		field, ok := mapping[col]
+		if !ok {
+			if !isAuthorField(col) {
+				continue
+			}
+			field = col
+		}
+		if _, dup := cols.fields[field]; dup {
+			return nil, fmt.Errorf("more than one column is mapped to %s", field)
+		}
+		cols.fields[field] = i
+	}
+	if _, ok := cols.fields[fieldName]; !ok {
+		return nil, errors.New("the header has no column mapped to name")
+	}
+	return cols, nil
+}
+
+// value returns the record value for field and whether the column exists.
+func (c *columns) value(record []string, field string) (string, bool) {
+	i, ok := c.fields[field]
+	if !ok {
+		return "", false
+	}
+	return record[i], true
+}
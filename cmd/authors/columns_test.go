@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestColumnMapSet(t *testing.T) {
+	cases := []struct {
+		value string
+		ok    bool
+	}{
+		{"Full Name=name", true},
+		{" born = birth_year ", true},
+		{"name", false},
+		{"=name", false},
+		{"col=", false},
+		{"col=rating", false},
+	}
+	for _, c := range cases {
+		err := columnMap{}.Set(c.value)
+		if (err == nil) != c.ok {
+			t.Errorf("Expected mapping %q to be valid: %v, got error %v", c.value, c.ok, err)
+		}
+	}
+
+	mapping := columnMap{}
+	mapping.Set("Full Name=name")
+	if mapping["full name"] != fieldName {
+		t.Errorf("Expected the column to be normalized but got %v", mapping)
+	}
+}
+
+func TestResolveColumns(t *testing.T) {
+	cases := []struct {
+		header   []string
+		mapping  columnMap
+		expected map[string]int
+	}{
+		{[]string{"name"}, columnMap{}, map[string]int{fieldName: 0}},
+		{[]string{utf8BOM + "Name", "Birth_Year", "notes"}, columnMap{}, map[string]int{fieldName: 0, fieldBirthYear: 1}},
+		{[]string{"id", "author", "country"}, columnMap{"author": fieldName, "country": fieldNationality},
+			map[string]int{fieldName: 1, fieldNationality: 2}},
+		// Mapped columns win over the ones named like the field
+		{[]string{"name", "alias"}, columnMap{"alias": fieldExternalId}, map[string]int{fieldName: 0, fieldExternalId: 1}},
+		{[]string{"author"}, columnMap{}, nil},
+		{[]string{"name", "author"}, columnMap{"author": fieldName}, nil},
+	}
+	for _, c := range cases {
+		cols, err := resolveColumns(c.header, c.mapping)
+		if c.expected == nil {
+			if err == nil {
+				t.Errorf("Expected header %v with %v to fail", c.header, c.mapping)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected header %v with %v to be resolved but got %v", c.header, c.mapping, err)
+			continue
+		}
+		if cols.count != len(c.header) || !reflect.DeepEqual(cols.fields, c.expected) {
+			t.Errorf("Expected columns %v for %v but got %v", c.expected, c.header, cols.fields)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+// TestReadExport imports the files written by the export command tests, so
+// the authors exported as CSV or JSON Lines can be loaded back as they are.
+func TestReadExport(t *testing.T) {
+	birthYear := 1839
+	nationality := "BR"
+	externalId := "Q311145"
+	expected := []*models.Author{
+		{Name: "Machado de Assis", BirthYear: &birthYear, Nationality: &nationality, ExternalId: &externalId},
+		{Name: "O'Brien, Flann"},
+		{Name: `Dwayne "The Rock" Johnson`},
+	}
+	for _, file := range []string{"authors.csv", "authors.jsonl"} {
+		name := filepath.Join("..", "export", "testdata", file)
+		in, err := openInput(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+		opts := readerOptions{format: detectFormat(name), delimiter: ','}
+		reader, header, err := readHeader(in, opts, columnMap{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols, err := resolveColumns(header, columnMap{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authors := []*models.Author{}
+		for {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			author, reason := validateAuthor(record, cols)
+			if reason != "" {
+				t.Fatalf("Expected the exported %q to be valid in %s but got %s", record, file, reason)
+			}
+			authors = append(authors, author)
+		}
+		if !reflect.DeepEqual(authors, expected) {
+			t.Errorf("Expected the authors of %s to be read back but got %+v", file, authors)
+		}
+	}
+}
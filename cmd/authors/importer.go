@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+	"github.com/jcardenasc93/work-at-olist/cmd/internal/rejects"
+)
+
+// pipelineDepth is the number of parsed batches waiting to be written.
+const pipelineDepth = 4
+
+type importer struct {
+	store      db.ApiDB
+	batchSize  int
+	checkpoint *checkpoint
+	quarantine *rejects.Writer
+	onDup      db.OnDuplicate
+	columns    *columns
+	progress   *progress
+}
+
+// batch holds the valid authors parsed since the previous batch, how many
+// records were read and skipped to build it and the input offset after its
+// last record.
+type batch struct {
+	authors []*models.Author
+	read    int
+	skipped int
+	offset  int64
+}
+
+// run streams the input records into the database. One goroutine parses and
+// validates the records while the caller goroutine writes them, committing one
+// transaction per batch. Invalid records are sent to the quarantine instead of
+// stopping the import. The checkpoint, when enabled, is saved in the
+// transaction of each batch with the offset of its last record. The returned
+// stats include the ones of previous resumed runs.
+func (im *importer) run(ctx context.Context, reader recordReader) (importStats, error) {
+	var stats importStats
+	var baseOffset int64
+	var baseLine int
+	if im.checkpoint != nil {
+		stats = im.checkpoint.Stats
+		baseOffset = im.checkpoint.Offset
+		baseLine = im.checkpoint.line
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	batches := make(chan *batch, pipelineDepth)
+	parseErr := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		parseErr <- im.parse(ctx, reader, batches, baseOffset, baseLine)
+	}()
+
+	// stop cancels the parser and waits for it, so it no longer uses the
+	// quarantine once run returns.
+	stop := func(err error) (importStats, error) {
+		cancel()
+		for range batches {
+		}
+		<-parseErr
+		return stats, err
+	}
+
+	for b := range batches {
+		read := stats
+		read.Read += b.read
+		read.Skipped += b.skipped
+		var cp *db.ImportCheckpoint
+		if im.checkpoint != nil {
+			cp = im.checkpoint.next(read, b.offset)
+		}
+		matched, err := im.store.InsertAuthors(ctx, b.authors, im.onDup, cp)
+		if err != nil {
+			return stop(err)
+		}
+		stats = read
+		stats.Imported += len(b.authors) - matched
+		stats.Duplicated += matched
+		im.progress.addImported(len(b.authors) - matched)
+	}
+	return stats, <-parseErr
+}
+
+// parse reads and validates the input records and sends them to batches
+// every batchSize valid records. The quarantine is flushed before sending a
+// batch, so it's on disk once the batch and its checkpoint are committed.
+func (im *importer) parse(ctx context.Context, reader recordReader, batches chan<- *batch, baseOffset int64, baseLine int) error {
+	b := &batch{authors: make([]*models.Author, 0, im.batchSize)}
+	send := func() error {
+		if err := im.quarantine.Flush(); err != nil {
+			return err
+		}
+		b.offset = baseOffset + reader.InputOffset()
+		select {
+		case batches <- b:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		b = &batch{authors: make([]*models.Author, 0, im.batchSize)}
+		return nil
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			b.read++
+			b.skipped++
+			im.progress.addRows(1)
+			reason := fmt.Sprintf("%s: %s", reasonMalformed, parseErr.Err)
+			if err = im.quarantine.Add(baseLine+parseErr.StartLine, reason, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		b.read++
+		im.progress.addRows(1)
+		line, _ := reader.FieldPos(0)
+		author, reason := validateAuthor(record, im.columns)
+		if reason != "" {
+			b.skipped++
+			if err = im.quarantine.Add(baseLine+line, reason, record); err != nil {
+				return err
+			}
+			continue
+		}
+		b.authors = append(b.authors, author)
+		if len(b.authors) == im.batchSize {
+			if err = send(); err != nil {
+				return err
+			}
+		}
+	}
+	return send()
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+	"github.com/jcardenasc93/work-at-olist/cmd/internal/rejects"
+)
+
+// failingStore records the size of every batch inserted and fails once
+// insertsLeft batches are stored, like a crash in the middle of an import.
+type failingStore struct {
+	*db.MockDB
+	batches     []int
+	insertsLeft int
+}
+
+func (f *failingStore) InsertAuthors(ctx context.Context, authors []*models.Author, onDup db.OnDuplicate, cp *db.ImportCheckpoint) (int, error) {
+	if f.insertsLeft == 0 {
+		return 0, errors.New("disk full")
+	}
+	f.insertsLeft--
+	f.batches = append(f.batches, len(authors))
+	return f.MockDB.InsertAuthors(ctx, authors, onDup, cp)
+}
+
+func authorsCSV(n int) string {
+	content := "name\n"
+	for i := 1; i <= n; i++ {
+		content += fmt.Sprintf("Author %d\n", i)
+	}
+	return content
+}
+
+var csvOptions = readerOptions{format: formatCSV, delimiter: ','}
+
+// newTestReader returns a reader positioned after the header of content and
+// the columns it names.
+func newTestReader(t *testing.T, content string) (recordReader, *columns) {
+	t.Helper()
+	reader, header, err := readHeader(strings.NewReader(content), csvOptions, columnMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, err := resolveColumns(header, columnMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader, cols
+}
+
+func TestImportAuthors(t *testing.T) {
+	cases := []struct {
+		batchSize int
+		batches   []int
+	}{
+		{1, []int{1, 1, 1, 1, 1, 0}},
+		{2, []int{2, 2, 1}},
+		{5, []int{5, 0}},
+		{10, []int{5}},
+	}
+	for _, c := range cases {
+		store := &failingStore{MockDB: db.NewMockDB(), insertsLeft: -1}
+		reader, cols := newTestReader(t, authorsCSV(5))
+		im := &importer{
+			store:      store,
+			batchSize:  c.batchSize,
+			quarantine: rejects.NewWriter(filepath.Join(t.TempDir(), "q.csv"), false),
+			columns:    cols,
+		}
+		stats, err := im.run(context.Background(), reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Imported != 5 || len(store.Authors) != 5 {
+			t.Errorf("Expected 5 authors imported by %d but got %d, %d stored", c.batchSize, stats.Imported, len(store.Authors))
+		}
+		if !reflect.DeepEqual(store.batches, c.batches) {
+			t.Errorf("Expected batches %v by %d but got %v", c.batches, c.batchSize, store.batches)
+		}
+	}
+}
+
+func TestImportDuplicates(t *testing.T) {
+	store := db.NewMockDB()
+	store.Authors = append(store.Authors, models.NewAuthor(1, "Homer"))
+	reader, cols := newTestReader(t, "name\nVirgil\n HOMER\nvirgil\nOvid\n")
+	im := &importer{
+		store:      store,
+		batchSize:  2,
+		quarantine: rejects.NewWriter(filepath.Join(t.TempDir(), "q.csv"), false),
+		onDup:      db.DuplicateSkip,
+		columns:    cols,
+	}
+	stats, err := im.run(context.Background(), reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := importStats{Read: 4, Imported: 2, Duplicated: 2}
+	if stats != expected || len(store.Authors) != 3 {
+		t.Errorf("Expected stats %+v and 3 authors but got %+v, %d stored", expected, stats, len(store.Authors))
+	}
+}
+
+func TestImportProgress(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "authors.csv")
+	if err := os.WriteFile(name, []byte(authorsCSV(7)+"  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in, err := openInput(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	reader, header, err := readHeader(in, csvOptions, columnMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, err := resolveColumns(header, columnMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	im := &importer{
+		store:      db.NewMockDB(),
+		batchSize:  3,
+		quarantine: rejects.NewWriter(filepath.Join(t.TempDir(), "q.csv"), false),
+		columns:    cols,
+		progress:   newProgress(&out, progressJSON, time.Hour, in),
+	}
+	im.progress.start()
+	if _, err = im.run(context.Background(), reader); err != nil {
+		t.Fatal(err)
+	}
+	im.progress.finish()
+
+	var report progressReport
+	if err = json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("Expected a single JSON report but got %q: %v", out.String(), err)
+	}
+	if !report.Done || report.RowsRead != 8 || report.RowsImported != 7 || report.Percent == nil || *report.Percent != 100 {
+		t.Errorf("Expected the final report of the whole input but got %+v", report)
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	cases := []struct {
+		content string
+		n       int64
+		lines   int
+	}{
+		{"", 10, 0},
+		{"a\nb\nc\n", 4, 2},
+		{"a\nb\nc\n", 6, 3},
+		{"a\nb\nc", 100, 2},
+		{strings.Repeat("a\n", 40000), 80000, 40000},
+	}
+	for _, c := range cases {
+		lines, err := countLines(strings.NewReader(c.content), c.n)
+		if err != nil || lines != c.lines {
+			t.Errorf("Expected %d lines in the first %d bytes but got %d, %v", c.lines, c.n, lines, err)
+		}
+	}
+}
+
+func TestImportResume(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{
+		csvFile:       filepath.Join(dir, "authors.csv"),
+		checkpoint:    "authors",
+		quarantine:    filepath.Join(dir, "authors.quarantine.csv"),
+		readerOptions: csvOptions,
+	}
+	content := "name,birth_year\n"
+	for i := 1; i <= 25; i++ {
+		year := "1950"
+		if i%10 == 0 {
+			year = "soon"
+		}
+		content += fmt.Sprintf("Author %d,%s\n", i, year)
+	}
+	if err := os.WriteFile(cfg.csvFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := &failingStore{MockDB: db.NewMockDB(), insertsLeft: 2}
+
+	importFile := func(cfg *config) (importStats, error) {
+		in, err := openInput(cfg.csvFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { in.Close() }()
+		reader, header, err := readHeader(in, cfg.readerOptions, columnMap{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols, err := resolveColumns(header, columnMap{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp, err := setupCheckpoint(context.Background(), cfg, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cp.Offset > 0 {
+			in.Close()
+			if in, err = skipToCheckpoint(cfg, cp); err != nil {
+				t.Fatal(err)
+			}
+			reader = newRecordReader(in, cfg.readerOptions, header)
+		}
+		q := rejects.NewWriter(cfg.quarantine, cp.Offset > 0)
+		defer q.Close()
+		im := &importer{
+			store:      store,
+			batchSize:  4,
+			checkpoint: cp,
+			quarantine: q,
+			onDup:      db.DuplicateError,
+			columns:    cols,
+		}
+		return im.run(context.Background(), reader)
+	}
+
+	if _, err := importFile(cfg); err == nil {
+		t.Fatal("Expected the first run to fail")
+	}
+	saved, err := store.FetchImportCheckpoint(context.Background(), cfg.checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Authors) != 8 || saved.Imported != 8 || saved.Read != 8 {
+		t.Errorf("Expected the checkpoint to match the 8 committed authors but got %d stored and %+v", len(store.Authors), saved)
+	}
+
+	// The resumed run starts after the committed batches, so DuplicateError
+	// doesn't abort on the authors already stored
+	store.insertsLeft = -1
+	cfg.resume = true
+	stats, err := importFile(cfg)
+	if err != nil {
+		t.Fatalf("Expected the resumed run to finish but got %v", err)
+	}
+	expected := importStats{Read: 25, Imported: 23, Skipped: 2}
+	if stats != expected || len(store.Authors) != 23 {
+		t.Errorf("Expected stats %+v without duplicates but got %+v, %d stored", expected, stats, len(store.Authors))
+	}
+	for i, author := range store.Authors {
+		if strings.HasSuffix(author.Name, "0") || author.BirthYear == nil || *author.BirthYear != 1950 {
+			t.Errorf("Expected only valid authors but got %+v at position %d", author, i+1)
+		}
+	}
+	// The row rejected by the failed batch is read again, but only
+	// quarantined once
+	quarantined, _ := os.ReadFile(cfg.quarantine)
+	if lines := strings.Count(string(quarantined), "\n"); lines != 3 {
+		t.Errorf("Expected the header and 2 rejected rows but got %q", quarantined)
+	}
+
+	// A checkpoint is only valid for the input it was created for
+	if err = os.WriteFile(cfg.csvFile, []byte(authorsCSV(12)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = setupCheckpoint(context.Background(), cfg, store); err == nil {
+		t.Error("Expected the checkpoint of another input to be rejected")
+	}
+}
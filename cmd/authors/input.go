@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported input formats.
+const (
+	formatCSV   = "csv"
+	formatTSV   = "tsv"
+	formatJSONL = "jsonl"
+)
+
+// Supported input compressions.
+const (
+	compressionNone = ""
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// recordReader reads the input records one at a time. Every format is turned
+// into CSV like records so they share the same validation and import logic.
+type recordReader interface {
+	Read() ([]string, error)
+	FieldPos(field int) (line, column int)
+	InputOffset() int64
+}
+
+type readerOptions struct {
+	format     string
+	delimiter  rune
+	lazyQuotes bool
+}
+
+// input is the decompressed content of the file being imported. size is the
+// raw file size, or zero when unknown.
+type input struct {
+	io.Reader
+	raw     *countingReader
+	size    int64
+	closers []io.Closer
+}
+
+// bytesRead returns how many raw bytes were read from the file so far.
+func (in *input) bytesRead() int64 {
+	return in.raw.n.Load()
+}
+
+// countingReader counts the bytes read through it. It can be read from other
+// goroutines.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (in *input) Close() error {
+	var err error
+	for i := len(in.closers) - 1; i >= 0; i-- {
+		if closeErr := in.closers[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// openInput opens name, or stdin for "-", and decompresses it when its
+// extension or its first bytes match a supported compression.
+func openInput(name string) (*input, error) {
+	in := new(input)
+	file := os.Stdin
+	if name != stdinName {
+		var err error
+		if file, err = os.Open(name); err != nil {
+			return nil, err
+		}
+		in.closers = append(in.closers, file)
+		if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+			in.size = info.Size()
+		}
+	}
+	in.raw = &countingReader{r: file}
+	buffered := bufio.NewReader(in.raw)
+	in.Reader = buffered
+
+	compression, err := detectCompression(name, buffered)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	switch compression {
+	case compressionGzip:
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in.Reader = gz
+		in.closers = append(in.closers, gz)
+	case compressionZstd:
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in.Reader = zr
+		in.closers = append(in.closers, zr.IOReadCloser())
+	}
+	return in, nil
+}
+
+func detectCompression(name string, r *bufio.Reader) (string, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".gzip":
+		return compressionGzip, nil
+	case ".zst", ".zstd":
+		return compressionZstd, nil
+	}
+	magic, err := r.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if bytes.HasPrefix(magic, gzipMagic) {
+		return compressionGzip, nil
+	}
+	if bytes.HasPrefix(magic, zstdMagic) {
+		return compressionZstd, nil
+	}
+	return compressionNone, nil
+}
+
+// detectFormat guesses the input format from the file extension, ignoring the
+// compression one. CSV is used when nothing matches.
+func detectFormat(name string) string {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".gz", ".gzip", ".zst", ".zstd"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	switch filepath.Ext(name) {
+	case ".tsv", ".tab":
+		return formatTSV
+	case ".jsonl", ".ndjson":
+		return formatJSONL
+	}
+	return formatCSV
+}
+
+// newRecordReader returns the reader for opts.format. JSON Lines has no
+// header, so header is used to pick the record values from every object.
+func newRecordReader(r io.Reader, opts readerOptions, header []string) recordReader {
+	if opts.format == formatJSONL {
+		return newJSONLReader(r, header)
+	}
+	csvReader := csv.NewReader(r)
+	// The column count is checked by validateAuthor to quarantine bad rows
+	csvReader.FieldsPerRecord = -1
+	csvReader.Comma = opts.delimiter
+	csvReader.LazyQuotes = opts.lazyQuotes
+	return csvReader
+}
+
+// readHeader returns the input column names along with the reader for the
+// remaining records. For JSON Lines the columns are the keys renamed by the
+// user mappings plus the author fields not mapped from another key.
+func readHeader(r io.Reader, opts readerOptions, mapping columnMap) (recordReader, []string, error) {
+	if opts.format != formatJSONL {
+		reader := newRecordReader(r, opts, nil)
+		header, err := reader.Read()
+		return reader, header, err
+	}
+	mapped := map[string]bool{}
+	header := []string{}
+	for key, field := range mapping {
+		mapped[field] = true
+		header = append(header, key)
+	}
+	for _, field := range authorFields {
+		if !mapped[field] {
+			header = append(header, field)
+		}
+	}
+	return newRecordReader(r, opts, header), header, nil
+}
+
+// parseDelimiter accepts a single character or the \t escape sequence.
+func parseDelimiter(value string) (rune, error) {
+	if value == `\t` || value == "tab" {
+		return '\t', nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("invalid delimiter %q, expected a single character", value)
+	}
+	return runes[0], nil
+}
+
+// jsonlReader reads one JSON object per line and returns the values of the
+// header keys as a record.
+type jsonlReader struct {
+	r      *bufio.Reader
+	keys   []string
+	offset int64
+	line   int
+	start  int
+}
+
+func newJSONLReader(r io.Reader, header []string) *jsonlReader {
+	keys := make([]string, len(header))
+	for i, key := range header {
+		keys[i] = normalizeColumn(key)
+	}
+	return &jsonlReader{
+		r:    bufio.NewReader(r),
+		keys: keys,
+	}
+}
+
+func (j *jsonlReader) Read() ([]string, error) {
+	for {
+		data, err := j.r.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return nil, err
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		j.offset += int64(len(data))
+		j.line++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		j.start = j.line
+		return j.parse(data)
+	}
+}
+
+func (j *jsonlReader) parse(data []byte) ([]string, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		// csv.ParseError lets the importer quarantine the line as malformed
+		return nil, &csv.ParseError{StartLine: j.line, Line: j.line, Err: err}
+	}
+	values := make(map[string]json.RawMessage, len(object))
+	for key, value := range object {
+		values[normalizeColumn(key)] = value
+	}
+
+	record := make([]string, len(j.keys))
+	for i, key := range j.keys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		str, err := jsonValue(value)
+		if err != nil {
+			err = fmt.Errorf("key %s: %w", key, err)
+			return nil, &csv.ParseError{StartLine: j.line, Line: j.line, Err: err}
+		}
+		record[i] = str
+	}
+	return record, nil
+}
+
+// jsonValue converts a JSON scalar to its string representation. Null values
+// are returned as an empty string.
+func jsonValue(raw json.RawMessage) (string, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", errors.New("expected a string, number, boolean or null value")
+}
+
+func (j *jsonlReader) FieldPos(field int) (int, int) {
+	return j.start, 1
+}
+
+func (j *jsonlReader) InputOffset() int64 {
+	return j.offset
+}
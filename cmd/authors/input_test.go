@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]string{
+		"authors.csv":         formatCSV,
+		"authors":             formatCSV,
+		"AUTHORS.TSV":         formatTSV,
+		"authors.tab.gz":      formatTSV,
+		"authors.jsonl.zst":   formatJSONL,
+		"authors.ndjson.gzip": formatJSONL,
+		"authors.csv.zstd":    formatCSV,
+		stdinName:             formatCSV,
+	}
+	for name, expected := range cases {
+		if format := detectFormat(name); format != expected {
+			t.Errorf("Expected format %s for %s but got %s", expected, name, format)
+		}
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  []byte
+		expected string
+	}{
+		{"authors.csv", []byte("name\n"), compressionNone},
+		{"authors.csv", []byte{}, compressionNone},
+		{"authors.gz", []byte("name\n"), compressionGzip},
+		{"authors.ZST", []byte("name\n"), compressionZstd},
+		{"authors.csv", append(gzipMagic, 0, 0), compressionGzip},
+		{stdinName, append(zstdMagic, 0), compressionZstd},
+	}
+	for _, c := range cases {
+		compression, err := detectCompression(c.name, bufio.NewReader(bytes.NewReader(c.content)))
+		if err != nil || compression != c.expected {
+			t.Errorf("Expected compression %q for %s %v but got %q, %v", c.expected, c.name, c.content, compression, err)
+		}
+	}
+}
+
+func TestOpenCompressedInput(t *testing.T) {
+	content := "name\nAna\n"
+	dir := t.TempDir()
+	gzPath := filepath.Join(dir, "authors.csv.gz")
+	gzFile, _ := os.Create(gzPath)
+	gz := gzip.NewWriter(gzFile)
+	gz.Write([]byte(content))
+	gz.Close()
+	gzFile.Close()
+
+	// No extension, so zstd is detected from the magic number
+	zstPath := filepath.Join(dir, "authors")
+	zstFile, _ := os.Create(zstPath)
+	zw, _ := zstd.NewWriter(zstFile)
+	zw.Write([]byte(content))
+	zw.Close()
+	zstFile.Close()
+
+	for _, path := range []string{gzPath, zstPath} {
+		in, err := openInput(path)
+		if err != nil {
+			t.Fatalf("Expected %s to be opened but got %v", path, err)
+		}
+		data, err := io.ReadAll(in)
+		in.Close()
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %q from %s but got %q, %v", content, path, data, err)
+		}
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	cases := map[string]rune{`\t`: '\t', "tab": '\t', ";": ';', "|": '|'}
+	for value, expected := range cases {
+		if r, err := parseDelimiter(value); err != nil || r != expected {
+			t.Errorf("Expected delimiter %q for %q but got %q, %v", expected, value, r, err)
+		}
+	}
+	for _, value := range []string{"", ";;"} {
+		if _, err := parseDelimiter(value); err == nil {
+			t.Errorf("Expected delimiter %q to be invalid", value)
+		}
+	}
+}
+
+func TestJSONLReader(t *testing.T) {
+	input := `{"Name": "Ana", "birth_year": 1950, "alive": true}
+
+{"name": "Bob", "birth_year": null}
+not json
+{"name": {"first": "Carl"}}
+{"name": "Dan"}`
+	mapping := columnMap{}
+	reader, header, err := readHeader(strings.NewReader(input), readerOptions{format: formatJSONL}, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, err := resolveColumns(header, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		name, birthYear string
+		line            int
+		malformed       bool
+	}
+	results := []result{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			results = append(results, result{line: parseErr.StartLine, malformed: true})
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, _ := cols.value(record, fieldName)
+		birthYear, _ := cols.value(record, fieldBirthYear)
+		line, _ := reader.FieldPos(0)
+		results = append(results, result{name: name, birthYear: birthYear, line: line})
+	}
+	expected := []result{
+		{name: "Ana", birthYear: "1950", line: 1},
+		{name: "Bob", line: 3},
+		{line: 4, malformed: true},
+		{line: 5, malformed: true},
+		{name: "Dan", line: 6},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Expected records %+v but got %+v", expected, results)
+	}
+	if offset := reader.InputOffset(); offset != int64(len(input)) {
+		t.Errorf("Expected offset %d at the end but got %d", len(input), offset)
+	}
+}
+
+func TestJSONLReaderMapping(t *testing.T) {
+	mapping := columnMap{}
+	mapping.Set("author=name")
+	reader, header, err := readHeader(strings.NewReader(`{"author": "Ana", "name": "ignored"}`+"\n"), readerOptions{format: formatJSONL}, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, err := resolveColumns(header, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := cols.value(record, fieldName); name != "Ana" {
+		t.Errorf("Expected the mapped key to be the name but got %q", name)
+	}
+}
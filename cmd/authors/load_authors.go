@@ -1,54 +1,260 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"errors"
 	"flag"
-	"io"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/jcardenasc93/work-at-olist/app/db"
+	"github.com/jcardenasc93/work-at-olist/cmd/internal/rejects"
 )
 
-const dbName = "sqlite.db"
+const stdinName = "-"
+const defaultBatchSize = 10000
+const quarantineSuffix = ".quarantine.csv"
+const stdinQuarantine = "stdin" + quarantineSuffix
 
-func main() {
-	os.Remove(dbName)
-	var csvFile string
-	flag.StringVar(&csvFile, "csv", "", "CSV file path")
+type config struct {
+	csvFile    string
+	dbName     string
+	reset      bool
+	batchSize  int
+	checkpoint string
+	resume     bool
+	quarantine string
+	onDup      string
+	mapping    columnMap
+	readerOptions
+	quiet    bool
+	progress string
+	interval time.Duration
+}
+
+func parseFlags() (*config, error) {
+	cfg := &config{mapping: columnMap{}}
+	flag.StringVar(&cfg.csvFile, "csv", "", "Input file path, use - to read from stdin. Gzip and zstd files are decompressed")
+	flag.StringVar(&cfg.dbName, "db", "", "SQLite database file (defaults to $dbName)")
+	flag.BoolVar(&cfg.reset, "reset", false, "Remove the database before importing")
+	flag.IntVar(&cfg.batchSize, "batch-size", defaultBatchSize, "Rows committed per transaction")
+	flag.StringVar(&cfg.checkpoint, "checkpoint", "", "Name of the checkpoint stored in the database (defaults to the absolute <csv> path)")
+	flag.BoolVar(&cfg.resume, "resume", false, "Resume the import from the last checkpoint")
+	flag.StringVar(&cfg.quarantine, "quarantine", "", "CSV file for rejected rows (defaults to <csv>"+quarantineSuffix+")")
+	flag.StringVar(&cfg.onDup, "on-duplicate", string(db.DuplicateSkip),
+		"What to do with rows matching an existing author: skip, keep (update the existing name) or error")
+	flag.Var(cfg.mapping, "map", "Map an input column to an author field as csvcol=field, can be repeated")
+	var delimiter string
+	flag.StringVar(&cfg.format, "format", "", "Input format: csv, tsv or jsonl (defaults to the file extension)")
+	flag.StringVar(&delimiter, "delimiter", "", "Field delimiter for csv and tsv inputs, use \\t for tabs")
+	flag.BoolVar(&cfg.lazyQuotes, "lazy-quotes", false, "Allow quotes in unquoted fields and unescaped quotes in quoted fields")
+	flag.BoolVar(&cfg.quiet, "quiet", false, "Don't report the import progress")
+	flag.StringVar(&cfg.progress, "progress", progressText, "Progress output format written to stderr: text or json")
+	flag.DurationVar(&cfg.interval, "progress-interval", defaultProgressInterval, "Time between progress reports")
 	flag.Parse()
 
-	file, err := os.Open(filepath.Base("../../input.csv"))
+	if cfg.csvFile == "" {
+		return nil, errors.New("missing -csv value")
+	}
+	if cfg.batchSize < 1 {
+		return nil, errors.New("-batch-size must be greater than zero")
+	}
+	if cfg.format == "" {
+		cfg.format = detectFormat(cfg.csvFile)
+	}
+	switch cfg.format {
+	case formatCSV:
+		cfg.delimiter = ','
+	case formatTSV:
+		// TSV files usually don't quote their fields
+		cfg.delimiter = '\t'
+		cfg.lazyQuotes = true
+	case formatJSONL:
+	default:
+		return nil, fmt.Errorf("invalid -format value %q", cfg.format)
+	}
+	if delimiter != "" {
+		var err error
+		if cfg.delimiter, err = parseDelimiter(delimiter); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.progress != progressText && cfg.progress != progressJSON {
+		return nil, fmt.Errorf("invalid -progress value %q", cfg.progress)
+	}
+	if cfg.interval <= 0 {
+		return nil, errors.New("-progress-interval must be greater than zero")
+	}
+	switch db.OnDuplicate(cfg.onDup) {
+	case db.DuplicateSkip, db.DuplicateKeep, db.DuplicateError:
+	default:
+		return nil, fmt.Errorf("invalid -on-duplicate value %q", cfg.onDup)
+	}
+	if cfg.resume && cfg.reset {
+		return nil, errors.New("-resume and -reset can't be used together")
+	}
+	if cfg.csvFile == stdinName {
+		if cfg.resume {
+			return nil, errors.New("-resume is not supported when reading from stdin")
+		}
+		if cfg.checkpoint != "" {
+			return nil, errors.New("-checkpoint is not supported when reading from stdin")
+		}
+		if cfg.quarantine == "" {
+			cfg.quarantine = stdinQuarantine
+		}
+	} else {
+		if cfg.checkpoint == "" {
+			path, err := filepath.Abs(cfg.csvFile)
+			if err != nil {
+				return nil, err
+			}
+			cfg.checkpoint = path
+		}
+		if cfg.quarantine == "" {
+			cfg.quarantine = cfg.csvFile + quarantineSuffix
+		}
+	}
+	var err error
+	if cfg.dbName, err = db.ResolveName(cfg.dbName); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// setupCheckpoint creates the checkpoint for the current input, or loads the
+// stored one when resuming.
+func setupCheckpoint(ctx context.Context, cfg *config, store db.ApiDB) (*checkpoint, error) {
+	if cfg.checkpoint == "" {
+		return nil, nil
+	}
+	inputHash, err := hashFile(cfg.csvFile)
 	if err != nil {
+		return nil, err
+	}
+	if !cfg.resume {
+		return newCheckpoint(cfg.checkpoint, inputHash), nil
+	}
+
+	cp, err := loadCheckpoint(ctx, store, cfg.checkpoint, inputHash)
+	if errors.Is(err, db.ErrNotFound) {
+		log.Printf("No checkpoint found at %s, starting from the beginning", cfg.checkpoint)
+		return newCheckpoint(cfg.checkpoint, inputHash), nil
+	}
+	return cp, err
+}
+
+// skipToCheckpoint reopens the input and discards everything up to the last
+// committed offset, so it works for compressed inputs too. The quarantined
+// rows after it are dropped, since they're read again.
+func skipToCheckpoint(cfg *config, cp *checkpoint) (*input, error) {
+	in, err := openInput(cfg.csvFile)
+	if err != nil {
+		return nil, err
+	}
+	if cp.line, err = countLines(in, cp.Offset); err != nil {
+		in.Close()
+		return nil, err
+	}
+	if err = rejects.Trim(cfg.quarantine, cp.line); err != nil {
+		in.Close()
+		return nil, err
+	}
+	log.Printf("Resuming import after line %d", cp.line)
+	return in, nil
+}
+
+func main() {
+	cfg, err := parseFlags()
+	if err != nil {
+		flag.Usage()
 		log.Fatal(err)
 	}
-	csvReader := csv.NewReader(file)
-	db, err := db.NewSQLiteDB()
+
+	if cfg.reset {
+		log.Printf("Removing database %s...", cfg.dbName)
+		err = os.Remove(cfg.dbName)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatal(err)
+		}
+	}
+
+	in, err := openInput(cfg.csvFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = db.CreateAuthorTable()
+	defer func() { in.Close() }()
+
+	reader, header, err := readHeader(in, cfg.readerOptions, cfg.mapping)
+	if err != nil {
+		log.Fatalf("Couldn't read the input header: %s", err)
+	}
+	cols, err := resolveColumns(header, cfg.mapping)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Println("Importing authors from csv file...")
-	for {
-		author, err := csvReader.Read()
-		if errors.Is(err, io.EOF) {
-			break
-		}
-		if author[0] != "name" {
-			err = db.InsertAuthor(author[0])
-			if err != nil {
-				log.Fatal(err)
-			}
+	store, err := db.OpenSQLiteDB(cfg.dbName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = store.CreateAuthorTable()
+	if err == nil {
+		err = store.CreateImportCheckpointTable()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	cp, err := setupCheckpoint(ctx, cfg, store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cp != nil && cp.Offset > 0 {
+		in.Close()
+		if in, err = skipToCheckpoint(cfg, cp); err != nil {
+			log.Fatal(err)
 		}
+		reader = newRecordReader(in, cfg.readerOptions, header)
+	}
 
+	log.Printf("Importing authors from %s file...", cfg.format)
+	start := time.Now()
+	// The rejected rows of a resumed run are appended to the previous ones
+	q := rejects.NewWriter(cfg.quarantine, cp != nil && cp.Offset > 0)
+	im := &importer{
+		store:      store,
+		batchSize:  cfg.batchSize,
+		checkpoint: cp,
+		quarantine: q,
+		onDup:      db.OnDuplicate(cfg.onDup),
+		columns:    cols,
+	}
+	if !cfg.quiet {
+		im.progress = newProgress(os.Stderr, cfg.progress, cfg.interval, in)
+	}
+	im.progress.start()
+	stats, err := im.run(ctx, reader)
+	im.progress.finish()
+	if closeErr := q.Close(); closeErr != nil {
+		log.Print(closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cp != nil {
+		if err = cp.remove(ctx, store); err != nil {
+			log.Print(err)
+		}
+	}
+	log.Printf("Done! Import finished in %s", time.Since(start))
+	stats.log()
+	if stats.Skipped > 0 {
+		log.Printf("Rejected rows written to %s", cfg.quarantine)
 	}
-	defer file.Close()
-	log.Println("Done!")
 
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Progress output formats.
+const (
+	progressText = "text"
+	progressJSON = "json"
+)
+
+const defaultProgressInterval = 2 * time.Second
+
+// progress periodically reports the import throughput. Rows are counted by
+// the importer and bytes by the input, both can be updated concurrently.
+type progress struct {
+	out      io.Writer
+	format   string
+	interval time.Duration
+	input    *input
+	rows     atomic.Int64
+	imported atomic.Int64
+
+	startTime  time.Time
+	startBytes int64
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+type progressReport struct {
+	RowsRead     int64    `json:"rows_read"`
+	RowsImported int64    `json:"rows_imported"`
+	RowsPerSec   float64  `json:"rows_per_sec"`
+	BytesRead    int64    `json:"bytes_read"`
+	BytesTotal   int64    `json:"bytes_total,omitempty"`
+	Percent      *float64 `json:"percent,omitempty"`
+	ETASeconds   *float64 `json:"eta_seconds,omitempty"`
+	Elapsed      float64  `json:"elapsed_seconds"`
+	Done         bool     `json:"done"`
+}
+
+func newProgress(out io.Writer, format string, interval time.Duration, in *input) *progress {
+	return &progress{
+		out:      out,
+		format:   format,
+		interval: interval,
+		input:    in,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *progress) addRows(n int) {
+	if p != nil {
+		p.rows.Add(int64(n))
+	}
+}
+
+func (p *progress) addImported(n int) {
+	if p != nil {
+		p.imported.Add(int64(n))
+	}
+}
+
+// start reports the progress every interval until finish is called.
+func (p *progress) start() {
+	if p == nil {
+		return
+	}
+	p.startTime = time.Now()
+	p.startBytes = p.input.bytesRead()
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.report(false)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// finish stops the periodic reports and writes the final one.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.report(true)
+}
+
+func (p *progress) snapshot(done bool) progressReport {
+	elapsed := time.Since(p.startTime).Seconds()
+	r := progressReport{
+		RowsRead:     p.rows.Load(),
+		RowsImported: p.imported.Load(),
+		BytesRead:    p.input.bytesRead(),
+		BytesTotal:   p.input.size,
+		Elapsed:      elapsed,
+		Done:         done,
+	}
+	if elapsed > 0 {
+		r.RowsPerSec = float64(r.RowsRead) / elapsed
+	}
+	if r.BytesTotal > 0 {
+		percent := 100 * float64(r.BytesRead) / float64(r.BytesTotal)
+		r.Percent = &percent
+		if read := r.BytesRead - p.startBytes; read > 0 {
+			eta := elapsed * float64(r.BytesTotal-r.BytesRead) / float64(read)
+			r.ETASeconds = &eta
+		}
+	}
+	return r
+}
+
+func (p *progress) report(done bool) {
+	r := p.snapshot(done)
+	if p.format == progressJSON {
+		data, err := json.Marshal(r)
+		if err == nil {
+			fmt.Fprintln(p.out, string(data))
+		}
+		return
+	}
+
+	line := fmt.Sprintf("Progress: %d rows read, %d imported, %.0f rows/s", r.RowsRead, r.RowsImported, r.RowsPerSec)
+	if r.Percent != nil {
+		line = fmt.Sprintf("%s, %.1f%% of input", line, *r.Percent)
+	}
+	if r.ETASeconds != nil && !done {
+		eta := time.Duration(*r.ETASeconds * float64(time.Second)).Round(time.Second)
+		line = fmt.Sprintf("%s, ETA %s", line, eta)
+	}
+	fmt.Fprintln(p.out, line)
+}
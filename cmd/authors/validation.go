@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+// maxNameLen matches the VARCHAR(64) size of the author text columns.
+const maxNameLen = 64
+
+// minBirthYear is the oldest birth year accepted in the input.
+const minBirthYear = -3000
+
+const (
+	reasonMalformed        = "malformed_record"
+	reasonColumnCount      = "wrong_column_count"
+	reasonInvalidUTF8      = "invalid_utf8"
+	reasonBlankName        = "blank_name"
+	reasonNameTooLong      = "name_too_long"
+	reasonInvalidBirthYear = "invalid_birth_year"
+	reasonValueTooLong     = "value_too_long"
+)
+
+type importStats struct {
+	Read       int `json:"read"`
+	Imported   int `json:"imported"`
+	Skipped    int `json:"skipped"`
+	Duplicated int `json:"duplicated"`
+}
+
+func (s importStats) log() {
+	log.Printf("Rows read: %d", s.Read)
+	log.Printf("Rows imported: %d", s.Imported)
+	log.Printf("Rows skipped: %d", s.Skipped)
+	log.Printf("Rows matching existing authors: %d", s.Duplicated)
+}
+
+// validateAuthor checks a CSV record and returns the author to store. A non
+// empty reason means the record must be quarantined.
+func validateAuthor(record []string, cols *columns) (*models.Author, string) {
+	if len(record) != cols.count {
+		return nil, fmt.Sprintf("%s: expected %d got %d", reasonColumnCount, cols.count, len(record))
+	}
+	for _, value := range record {
+		if !utf8.ValidString(value) {
+			return nil, reasonInvalidUTF8
+		}
+	}
+
+	name, _ := cols.value(record, fieldName)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, reasonBlankName
+	}
+	if utf8.RuneCountInString(name) > maxNameLen {
+		return nil, fmt.Sprintf("%s: max %d characters", reasonNameTooLong, maxNameLen)
+	}
+	author := models.NewAuthor(0, name)
+
+	if value := optionalValue(record, cols, fieldBirthYear); value != "" {
+		year, err := strconv.Atoi(value)
+		if err != nil || year < minBirthYear || year > time.Now().Year() {
+			return nil, fmt.Sprintf("%s: %s", reasonInvalidBirthYear, value)
+		}
+		author.BirthYear = &year
+	}
+	textFields := []struct {
+		field string
+		dest  **string
+	}{
+		{fieldNationality, &author.Nationality},
+		{fieldExternalId, &author.ExternalId},
+	}
+	for _, f := range textFields {
+		value := optionalValue(record, cols, f.field)
+		if value == "" {
+			continue
+		}
+		if utf8.RuneCountInString(value) > maxNameLen {
+			return nil, fmt.Sprintf("%s: %s max %d characters", reasonValueTooLong, f.field, maxNameLen)
+		}
+		*f.dest = &value
+	}
+	return author, ""
+}
+
+// optionalValue returns the trimmed value of field, or an empty string when the
+// input has no column for it.
+func optionalValue(record []string, cols *columns, field string) string {
+	value, _ := cols.value(record, field)
+	return strings.TrimSpace(value)
+}
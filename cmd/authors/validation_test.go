@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAuthor(t *testing.T) {
+	cols, err := resolveColumns([]string{"name", "birth_year", "nationality", "external_id"}, columnMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("a", maxNameLen+1)
+	cases := []struct {
+		record []string
+		reason string
+	}{
+		{[]string{" Machado de Assis ", "1839", "BR", "Q311145"}, ""},
+		{[]string{"Homer", "-750", "", ""}, ""},
+		{[]string{"Ana", "", "  ", ""}, ""},
+		{[]string{"Ana", "1950"}, reasonColumnCount},
+		{[]string{"An\xffa", "", "", ""}, reasonInvalidUTF8},
+		{[]string{"   ", "", "", ""}, reasonBlankName},
+		{[]string{long, "", "", ""}, reasonNameTooLong},
+		{[]string{"Ana", "soon", "", ""}, reasonInvalidBirthYear},
+		{[]string{"Ana", "3000", "", ""}, reasonInvalidBirthYear},
+		{[]string{"Ana", "-3001", "", ""}, reasonInvalidBirthYear},
+		{[]string{"Ana", "", long, ""}, reasonValueTooLong},
+	}
+	for _, c := range cases {
+		author, reason := validateAuthor(c.record, cols)
+		if !strings.HasPrefix(reason, c.reason) || (c.reason == "") != (reason == "") {
+			t.Errorf("Expected reason %q for %q but got %q", c.reason, c.record, reason)
+		}
+		if (author == nil) == (c.reason == "") {
+			t.Errorf("Expected an author only for valid records, got %v for %q", author, c.record)
+		}
+	}
+
+	author, _ := validateAuthor([]string{" Machado de Assis ", "1839", "BR", ""}, cols)
+	if author.Name != "Machado de Assis" || *author.BirthYear != 1839 || *author.Nationality != "BR" || author.ExternalId != nil {
+		t.Errorf("Expected the record values to be trimmed and empty ones left nil but got %+v", author)
+	}
+}
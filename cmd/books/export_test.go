@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+// TestReadExport imports the files written by the export command tests, so
+// the books exported as CSV or JSON Lines can be loaded back as they are.
+func TestReadExport(t *testing.T) {
+	expected := []*models.CreateBookReq{
+		{Name: "Dom Casmurro", Edition: 1, PubYear: 1899},
+		{Name: `At Swim-Two-Birds, "annotated"`, Edition: 2, PubYear: 1939},
+	}
+	expectedAuthors := [][]authorRef{{{id: 1}}, {{id: 2}, {id: 3}}}
+	for _, file := range []string{"books.csv", "books.jsonl"} {
+		in, err := os.Open(filepath.Join("..", "export", "testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+		var rows rowReader = newJSONLRows(in, authorsByAuto)
+		if filepath.Ext(file) == ".csv" {
+			if rows, err = newCSVRows(in, ";", authorsByAuto); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		all := readRows(t, rows)
+		if len(all) != len(expected) {
+			t.Fatalf("Expected %d books in %s but got %d", len(expected), file, len(all))
+		}
+		for i, row := range all {
+			if row.reason != "" {
+				t.Fatalf("Expected the exported %q to be valid in %s but got %s", row.record, file, row.reason)
+			}
+			if !reflect.DeepEqual(row.req, expected[i]) || !reflect.DeepEqual(row.authors, expectedAuthors[i]) {
+				t.Errorf("Expected the book %d of %s to be read back but got %+v %+v", i+1, file, row.req, row.authors)
+			}
+		}
+	}
+}
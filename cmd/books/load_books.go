@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+	"github.com/jcardenasc93/work-at-olist/cmd/internal/rejects"
+)
+
+const stdinName = "-"
+const defaultBatchSize = 1000
+const reportSuffix = ".report.csv"
+
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+type config struct {
+	file      string
+	dbName    string
+	format    string
+	batchSize int
+	report    string
+	authorSep string
+	authorsBy string
+}
+
+func parseFlags() (*config, error) {
+	cfg := new(config)
+	flag.StringVar(&cfg.file, "file", "", "CSV or JSON Lines file path, use - to read from stdin")
+	flag.StringVar(&cfg.dbName, "db", "", "SQLite database file (defaults to $dbName)")
+	flag.StringVar(&cfg.format, "format", "", "Input format: csv or jsonl (defaults to the file extension)")
+	flag.IntVar(&cfg.batchSize, "batch-size", defaultBatchSize, "Books committed per transaction")
+	flag.StringVar(&cfg.report, "report", "", "CSV file for rejected rows (defaults to <file>"+reportSuffix+")")
+	flag.StringVar(&cfg.authorSep, "author-sep", ";", "Separator of the authors listed in a CSV column")
+	flag.StringVar(&cfg.authorsBy, "authors-by", authorsByAuto,
+		"How authors are given: id, name or auto (numbers are ids, anything else a name)")
+	flag.Parse()
+
+	if cfg.file == "" {
+		return nil, errors.New("missing -file value")
+	}
+	if cfg.batchSize < 1 {
+		return nil, errors.New("-batch-size must be greater than zero")
+	}
+	if cfg.format == "" {
+		cfg.format = formatCSV
+		switch strings.ToLower(filepath.Ext(cfg.file)) {
+		case ".jsonl", ".ndjson":
+			cfg.format = formatJSONL
+		}
+	}
+	if cfg.format != formatCSV && cfg.format != formatJSONL {
+		return nil, fmt.Errorf("invalid -format value %q", cfg.format)
+	}
+	switch cfg.authorsBy {
+	case authorsByAuto, authorsById, authorsByName:
+	default:
+		return nil, fmt.Errorf("invalid -authors-by value %q", cfg.authorsBy)
+	}
+	if cfg.authorSep == "" {
+		return nil, errors.New("-author-sep can't be empty")
+	}
+	if cfg.report == "" {
+		if cfg.file == stdinName {
+			cfg.report = "stdin" + reportSuffix
+		} else {
+			cfg.report = cfg.file + reportSuffix
+		}
+	}
+	var err error
+	if cfg.dbName, err = db.ResolveName(cfg.dbName); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func main() {
+	cfg, err := parseFlags()
+	if err != nil {
+		flag.Usage()
+		log.Fatal(err)
+	}
+
+	file := os.Stdin
+	if cfg.file != stdinName {
+		if file, err = os.Open(cfg.file); err != nil {
+			log.Fatal(err)
+		}
+	}
+	defer file.Close()
+
+	var rows rowReader
+	if cfg.format == formatJSONL {
+		rows = newJSONLRows(file, cfg.authorsBy)
+	} else if rows, err = newCSVRows(file, cfg.authorSep, cfg.authorsBy); err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := db.OpenSQLiteDB(cfg.dbName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = store.Setup(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Importing books from %s file...", cfg.format)
+	start := time.Now()
+	rep := rejects.NewWriter(cfg.report, false)
+	im := &importer{
+		store:     store,
+		batchSize: cfg.batchSize,
+		report:    rep,
+	}
+	stats, err := im.run(context.Background(), rows)
+	if closeErr := rep.Close(); closeErr != nil {
+		log.Print(closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Done! Import finished in %s", time.Since(start))
+	log.Printf("Rows read: %d", stats.read)
+	log.Printf("Books imported: %d", stats.imported)
+	log.Printf("Rows rejected: %d", stats.rejected)
+	if stats.rejected > 0 {
+		log.Printf("Rejected rows written to %s", cfg.report)
+	}
+}
+
+type importStats struct {
+	read     int
+	imported int
+	rejected int
+}
+
+type importer struct {
+	store     db.ApiDB
+	batchSize int
+	report    *rejects.Writer
+}
+
+// run reads the rows in batches, resolves their authors against the database
+// and stores the valid books of every batch in a single transaction. Rows with
+// invalid values or unknown authors are written to the report.
+func (im *importer) run(ctx context.Context, rows rowReader) (importStats, error) {
+	var stats importStats
+	batch := make([]*bookRow, 0, im.batchSize)
+	for {
+		row, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.read++
+		if row.reason != "" {
+			stats.rejected++
+			if err = im.report.Add(row.line, row.reason, row.record); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		batch = append(batch, row)
+		if len(batch) == im.batchSize {
+			if err = im.flush(ctx, batch, &stats); err != nil {
+				return stats, err
+			}
+			batch = batch[:0]
+		}
+	}
+	return stats, im.flush(ctx, batch, &stats)
+}
+
+func (im *importer) flush(ctx context.Context, batch []*bookRow, stats *importStats) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := im.resolveAuthors(ctx, batch); err != nil {
+		return err
+	}
+
+	reqs := make([]*models.CreateBookReq, 0, len(batch))
+	for _, row := range batch {
+		if row.reason != "" {
+			stats.rejected++
+			if err := im.report.Add(row.line, row.reason, row.record); err != nil {
+				return err
+			}
+			continue
+		}
+		reqs = append(reqs, row.req)
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	books, err := im.store.InsertBooks(ctx, reqs)
+	if err != nil {
+		return err
+	}
+	stats.imported += len(books)
+	return nil
+}
+
+// resolveAuthors fills the authors ids of every row, rejecting the rows with
+// unknown authors. The lookups are done once for the whole batch.
+func (im *importer) resolveAuthors(ctx context.Context, batch []*bookRow) error {
+	names := []string{}
+	ids := []float64{}
+	for _, row := range batch {
+		for _, ref := range row.authors {
+			if ref.name != "" {
+				names = append(names, ref.name)
+			} else {
+				ids = append(ids, ref.id)
+			}
+		}
+	}
+
+	idsByName, err := im.store.FetchAuthorIdsByName(ctx, names)
+	if err != nil {
+		return err
+	}
+	missingIds, err := im.store.FetchMissingAuthorIds(ctx, ids)
+	if err != nil {
+		return err
+	}
+	missing := map[float64]bool{}
+	for _, id := range missingIds {
+		missing[id] = true
+	}
+
+	for _, row := range batch {
+		unknown := []string{}
+		seen := map[float64]bool{}
+		authors := []float64{}
+		for _, ref := range row.authors {
+			id := ref.id
+			if ref.name != "" {
+				var ok bool
+				if id, ok = idsByName[models.NormalizeAuthorName(ref.name)]; !ok {
+					unknown = append(unknown, ref.String())
+					continue
+				}
+			} else if missing[id] {
+				unknown = append(unknown, ref.String())
+				continue
+			}
+			if !seen[id] {
+				seen[id] = true
+				authors = append(authors, id)
+			}
+		}
+		if len(unknown) > 0 {
+			row.reject(fmt.Sprintf("%s: %s", reasonUnknownAuthors, strings.Join(unknown, ", ")))
+			continue
+		}
+		row.req.Authors = authors
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+	"github.com/jcardenasc93/work-at-olist/cmd/internal/rejects"
+)
+
+func TestImportBooks(t *testing.T) {
+	store := db.NewMockDB()
+	store.SetAuthors([]*models.Author{
+		{Id: 1, Name: "Homer"},
+		{Id: 2, Name: "Machado de Assis"},
+	})
+	input := "name,edition,publication_year,authors\n" +
+		"Odyssey,1,1999,Homer\n" +
+		"Dom Casmurro,1,1899,2;machado de assis\n" +
+		"Iliad,1,1999,Virgil\n" +
+		"Aeneid,1,1999,7\n" +
+		"Blank,0,1999,1\n" +
+		"Epics,2,2005,1;2\n"
+	rows, err := newCSVRows(strings.NewReader(input), ";", authorsByAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := filepath.Join(t.TempDir(), "books.report.csv")
+	rep := rejects.NewWriter(report, false)
+	im := &importer{store: store, batchSize: 2, report: rep}
+	stats, err := im.run(context.Background(), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rep.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats != (importStats{read: 6, imported: 3, rejected: 3}) {
+		t.Errorf("Expected 6 read, 3 imported and 3 rejected but got %+v", stats)
+	}
+	expected := map[string][]float64{"Odyssey": {1}, "Dom Casmurro": {2}, "Epics": {1, 2}}
+	for _, book := range store.Books {
+		if !reflect.DeepEqual(store.AuthorsBooks[book.Id], expected[book.Name]) {
+			t.Errorf("Expected authors %v for %s but got %v", expected[book.Name], book.Name, store.AuthorsBooks[book.Id])
+		}
+	}
+
+	file, err := os.Open(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := map[string]string{}
+	for _, record := range records[1:] {
+		reasons[record[0]] = record[1]
+	}
+	for line, reason := range map[string]string{"4": reasonUnknownAuthors, "5": reasonUnknownAuthors, "6": reasonInvalidValue} {
+		if !strings.HasPrefix(reasons[line], reason) {
+			t.Errorf("Expected reason %q at line %s but got %q", reason, line, reasons[line])
+		}
+	}
+	if len(reasons) != 3 {
+		t.Errorf("Expected 3 rejected rows but got %v", reasons)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+// How the authors values are interpreted.
+const (
+	authorsByAuto = "auto"
+	authorsById   = "id"
+	authorsByName = "name"
+)
+
+const (
+	colName    = "name"
+	colEdition = "edition"
+	colPubYear = "publication_year"
+	colAuthors = "authors"
+)
+
+const (
+	reasonMalformed      = "malformed_record"
+	reasonColumnCount    = "wrong_column_count"
+	reasonInvalidValue   = "invalid_value"
+	reasonMissingAuthors = "missing_authors"
+	reasonUnknownAuthors = "unknown_authors"
+)
+
+// authorRef is an author given either by id or by name.
+type authorRef struct {
+	id   float64
+	name string
+}
+
+func (a authorRef) String() string {
+	if a.name != "" {
+		return a.name
+	}
+	return strconv.FormatFloat(a.id, 'f', -1, 64)
+}
+
+// bookRow is a parsed input row. reason is set when the row must be rejected.
+type bookRow struct {
+	line    int
+	record  []string
+	req     *models.CreateBookReq
+	authors []authorRef
+	reason  string
+}
+
+func (r *bookRow) reject(reason string) *bookRow {
+	r.reason = reason
+	return r
+}
+
+// rowReader returns the input rows one at a time.
+type rowReader interface {
+	next() (*bookRow, error)
+}
+
+// csvRows reads books from a CSV file with a header row. The authors column
+// holds the list of authors split by sep.
+type csvRows struct {
+	r         *csv.Reader
+	columns   map[string]int
+	count     int
+	sep       string
+	authorsBy string
+}
+
+func newCSVRows(r io.Reader, sep string, authorsBy string) (*csvRows, error) {
+	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the input header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, col := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(col, "\ufeff")))] = i
+	}
+	for _, col := range []string{colName, colEdition, colPubYear, colAuthors} {
+		if _, ok := columns[col]; !ok {
+			return nil, fmt.Errorf("the header has no %s column", col)
+		}
+	}
+	return &csvRows{
+		r:         csvReader,
+		columns:   columns,
+		count:     len(header),
+		sep:       sep,
+		authorsBy: authorsBy,
+	}, nil
+}
+
+func (c *csvRows) next() (*bookRow, error) {
+	record, err := c.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		row := &bookRow{line: parseErr.StartLine}
+		return row.reject(fmt.Sprintf("%s: %s", reasonMalformed, parseErr.Err)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+	row := &bookRow{line: line, record: record}
+	if len(record) != c.count {
+		return row.reject(fmt.Sprintf("%s: expected %d got %d", reasonColumnCount, c.count, len(record))), nil
+	}
+
+	refs := []authorRef{}
+	for _, value := range strings.Split(record[c.columns[colAuthors]], c.sep) {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		ref, err := parseAuthorRef(value, c.authorsBy)
+		if err != nil {
+			return row.reject(fmt.Sprintf("%s: %s", reasonInvalidValue, err)), nil
+		}
+		refs = append(refs, ref)
+	}
+	return parseBook(row, record[c.columns[colName]], record[c.columns[colEdition]], record[c.columns[colPubYear]], refs), nil
+}
+
+// jsonlRows reads one book object per line.
+type jsonlRows struct {
+	r         *bufio.Reader
+	line      int
+	authorsBy string
+}
+
+type jsonBook struct {
+	Name    json.RawMessage   `json:"name"`
+	Edition json.RawMessage   `json:"edition"`
+	PubYear json.RawMessage   `json:"publication_year"`
+	Authors []json.RawMessage `json:"authors"`
+}
+
+func newJSONLRows(r io.Reader, authorsBy string) *jsonlRows {
+	return &jsonlRows{
+		r:         bufio.NewReader(r),
+		authorsBy: authorsBy,
+	}
+}
+
+func (j *jsonlRows) next() (*bookRow, error) {
+	for {
+		data, err := j.r.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return nil, err
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		j.line++
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		return j.parse(data), nil
+	}
+}
+
+func (j *jsonlRows) parse(data []byte) *bookRow {
+	row := &bookRow{line: j.line, record: []string{string(data)}}
+	var book jsonBook
+	if err := json.Unmarshal(data, &book); err != nil {
+		return row.reject(fmt.Sprintf("%s: %s", reasonMalformed, err))
+	}
+
+	refs := []authorRef{}
+	for _, raw := range book.Authors {
+		var id float64
+		if err := json.Unmarshal(raw, &id); err == nil && j.authorsBy != authorsByName {
+			if id < 1 || id != math.Trunc(id) {
+				return row.reject(fmt.Sprintf("%s: invalid author id %v", reasonInvalidValue, id))
+			}
+			refs = append(refs, authorRef{id: id})
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return row.reject(fmt.Sprintf("%s: authors must be ids or names", reasonInvalidValue))
+		}
+		authorsBy := j.authorsBy
+		if authorsBy == authorsByAuto {
+			// Unlike CSV, JSON tells numbers and strings apart
+			authorsBy = authorsByName
+		}
+		ref, err := parseAuthorRef(value, authorsBy)
+		if err != nil {
+			return row.reject(fmt.Sprintf("%s: %s", reasonInvalidValue, err))
+		}
+		refs = append(refs, ref)
+	}
+	return parseBook(row, jsonText(book.Name), jsonText(book.Edition), jsonText(book.PubYear), refs)
+}
+
+// jsonText returns a JSON string value unquoted and any other value as is.
+func jsonText(raw json.RawMessage) string {
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+func parseAuthorRef(value string, authorsBy string) (authorRef, error) {
+	id, err := strconv.ParseUint(value, 10, 64)
+	switch authorsBy {
+	case authorsById:
+		if err != nil || id == 0 {
+			return authorRef{}, fmt.Errorf("invalid author id %q", value)
+		}
+		return authorRef{id: float64(id)}, nil
+	case authorsByAuto:
+		if err == nil && id > 0 {
+			return authorRef{id: float64(id)}, nil
+		}
+	}
+	return authorRef{name: value}, nil
+}
+
+// parseBook validates the book values with the rules of the API and builds
+// the create request. The authors are resolved later against the database.
+func parseBook(row *bookRow, name string, edition string, pubYear string, authors []authorRef) *bookRow {
+	name = strings.TrimSpace(name)
+	if !utf8.ValidString(name) {
+		return row.reject(fmt.Sprintf("%s: name isn't valid UTF-8", reasonInvalidValue))
+	}
+	editionVal, err := strconv.ParseFloat(strings.TrimSpace(edition), 64)
+	if err != nil {
+		return row.reject(fmt.Sprintf("%s: edition must be a number", reasonInvalidValue))
+	}
+	pubYearVal, err := strconv.ParseFloat(strings.TrimSpace(pubYear), 64)
+	if err != nil {
+		return row.reject(fmt.Sprintf("%s: publication_year must be a number", reasonInvalidValue))
+	}
+	if len(authors) == 0 {
+		return row.reject(reasonMissingAuthors)
+	}
+
+	// The authors are set to an empty list to pass validation, their ids are
+	// only known once resolved.
+	req := &models.CreateBookReq{
+		Name:    name,
+		Edition: editionVal,
+		PubYear: pubYearVal,
+		Authors: []float64{},
+	}
+	if errs := req.Validate(nil); len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = e.Message
+		}
+		return row.reject(fmt.Sprintf("%s: %s", reasonInvalidValue, strings.Join(msgs, "; ")))
+	}
+	req.Authors = nil
+	row.authors = authors
+	row.req = req
+	return row
+}
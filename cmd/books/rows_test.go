@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+func TestParseAuthorRef(t *testing.T) {
+	cases := []struct {
+		value     string
+		authorsBy string
+		ref       authorRef
+		fails     bool
+	}{
+		{"12", authorsByAuto, authorRef{id: 12}, false},
+		{"Machado de Assis", authorsByAuto, authorRef{name: "Machado de Assis"}, false},
+		{"0", authorsByAuto, authorRef{name: "0"}, false},
+		{"-3", authorsByAuto, authorRef{name: "-3"}, false},
+		{"12", authorsById, authorRef{id: 12}, false},
+		{"0", authorsById, authorRef{}, true},
+		{"Homer", authorsById, authorRef{}, true},
+		{"12", authorsByName, authorRef{name: "12"}, false},
+		{"Homer", authorsByName, authorRef{name: "Homer"}, false},
+	}
+	for _, c := range cases {
+		ref, err := parseAuthorRef(c.value, c.authorsBy)
+		if (err != nil) != c.fails {
+			t.Errorf("Expected error %v for %q by %s but got %v", c.fails, c.value, c.authorsBy, err)
+		}
+		if ref != c.ref {
+			t.Errorf("Expected %+v for %q by %s but got %+v", c.ref, c.value, c.authorsBy, ref)
+		}
+	}
+}
+
+func TestParseBook(t *testing.T) {
+	nextYear := strconv.Itoa(time.Now().Year() + 1)
+	authors := []authorRef{{id: 1}, {name: "Homer"}}
+	cases := []struct {
+		name    string
+		edition string
+		pubYear string
+		authors []authorRef
+		reason  string
+	}{
+		{" Dom Casmurro ", "1", "1899", authors, ""},
+		{"Odyssey", " 3 ", " 1999 ", authors, ""},
+		{"  ", "1", "1899", authors, reasonInvalidValue},
+		{strings.Repeat("a", models.MaxBookNameLen+1), "1", "1899", authors, reasonInvalidValue},
+		{"Dom\xff", "1", "1899", authors, reasonInvalidValue},
+		{"Dom Casmurro", "0", "1899", authors, reasonInvalidValue},
+		{"Dom Casmurro", "first", "1899", authors, reasonInvalidValue},
+		{"Dom Casmurro", "1.5", "1899", authors, reasonInvalidValue},
+		{"Dom Casmurro", "1", "", authors, reasonInvalidValue},
+		{"Dom Casmurro", "1", nextYear, authors, reasonInvalidValue},
+		{"Dom Casmurro", "1", "1899", []authorRef{}, reasonMissingAuthors},
+	}
+	for _, c := range cases {
+		row := parseBook(&bookRow{line: 2}, c.name, c.edition, c.pubYear, c.authors)
+		if !strings.HasPrefix(row.reason, c.reason) || (c.reason == "") != (row.reason == "") {
+			t.Errorf("Expected reason %q for %q but got %q", c.reason, c.name, row.reason)
+		}
+		if (row.req == nil) == (c.reason == "") {
+			t.Errorf("Expected a request only for valid books, got %+v for %q", row.req, c.name)
+		}
+	}
+
+	row := parseBook(&bookRow{line: 2}, " Dom Casmurro ", " 2 ", "1899", authors)
+	if row.req.Name != "Dom Casmurro" || row.req.Edition != 2 || row.req.PubYear != 1899 || !reflect.DeepEqual(row.authors, authors) {
+		t.Errorf("Expected the values to be trimmed and parsed but got %+v %+v", row.req, row.authors)
+	}
+}
+
+func readRows(t *testing.T, rows rowReader) []*bookRow {
+	t.Helper()
+	all := []*bookRow{}
+	for {
+		row, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			return all
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, row)
+	}
+}
+
+func TestCSVRows(t *testing.T) {
+	input := "\ufeffName,Edition,Publication_Year,Authors\n" +
+		"Dom Casmurro,1,1899,12; Homer ;\n" +
+		"Odyssey,1,1999\n" +
+		"Iliad,1,1999,\n" +
+		"\"Broken,1,1999,1\n"
+	rows, err := newCSVRows(strings.NewReader(input), ";", authorsByAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := readRows(t, rows)
+	if len(all) != 4 {
+		t.Fatalf("Expected 4 rows but got %d", len(all))
+	}
+	if all[0].reason != "" || all[0].line != 2 || !reflect.DeepEqual(all[0].authors, []authorRef{{id: 12}, {name: "Homer"}}) {
+		t.Errorf("Expected the first row to be valid with two authors but got %+v", all[0])
+	}
+	for i, reason := range []string{reasonColumnCount, reasonMissingAuthors, reasonMalformed} {
+		if row := all[i+1]; !strings.HasPrefix(row.reason, reason) || row.line != i+3 {
+			t.Errorf("Expected reason %q at line %d but got %q at line %d", reason, i+3, row.reason, row.line)
+		}
+	}
+
+	if _, err = newCSVRows(strings.NewReader("name,edition,authors\n"), ";", authorsByAuto); err == nil {
+		t.Error("Expected an error for a header without publication_year")
+	}
+	rows, err = newCSVRows(strings.NewReader("name,edition,publication_year,authors\nIliad,1,1999,Homer\n"), ";", authorsById)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all = readRows(t, rows); !strings.HasPrefix(all[0].reason, reasonInvalidValue) {
+		t.Errorf("Expected a name to be rejected by id but got %q", all[0].reason)
+	}
+}
+
+func TestJSONLRows(t *testing.T) {
+	input := `{"name":"Dom Casmurro","edition":1,"publication_year":"1899","authors":[12,"Homer"]}` + "\n" +
+		"\n" +
+		`{"name":"Odyssey","edition":1,"publication_year":1999,"authors":[1.5]}` + "\n" +
+		`{"name":"Odyssey","edition":1,"publication_year":1999,"authors":[true]}` + "\n" +
+		`{"name":"Odyssey","edition":1,"publication_year":1999,"authors":[]}` + "\n" +
+		`{"name":null,"edition":1,"publication_year":1999,"authors":[1]}` + "\n" +
+		`{"name":"Odyssey",` + "\n" +
+		`{"name":"Iliad","edition":2,"publication_year":1999,"authors":["7"]}`
+	all := readRows(t, newJSONLRows(strings.NewReader(input), authorsByAuto))
+	if len(all) != 7 {
+		t.Fatalf("Expected 7 rows but got %d", len(all))
+	}
+	if all[0].reason != "" || all[0].line != 1 || !reflect.DeepEqual(all[0].authors, []authorRef{{id: 12}, {name: "Homer"}}) {
+		t.Errorf("Expected the first row to be valid with two authors but got %+v", all[0])
+	}
+	if all[0].req.PubYear != 1899 {
+		t.Errorf("Expected a quoted publication_year to be read but got %v", all[0].req.PubYear)
+	}
+	expected := []struct {
+		line   int
+		reason string
+	}{
+		{3, reasonInvalidValue},
+		{4, reasonInvalidValue},
+		{5, reasonMissingAuthors},
+		{6, reasonInvalidValue},
+		{7, reasonMalformed},
+	}
+	for i, e := range expected {
+		if row := all[i+1]; !strings.HasPrefix(row.reason, e.reason) || row.line != e.line {
+			t.Errorf("Expected reason %q at line %d but got %q at line %d", e.reason, e.line, row.reason, row.line)
+		}
+	}
+	// JSON strings are names unless the authors are given by id
+	if last := all[6]; last.reason != "" || !reflect.DeepEqual(last.authors, []authorRef{{name: "7"}}) {
+		t.Errorf("Expected a string author to be a name but got %+v", last)
+	}
+	all = readRows(t, newJSONLRows(strings.NewReader(`{"name":"Iliad","edition":2,"publication_year":1999,"authors":["7"]}`), authorsById))
+	if !reflect.DeepEqual(all[0].authors, []authorRef{{id: 7}}) {
+		t.Errorf("Expected a string author to be an id by id but got %+v", all[0])
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+	m "github.com/jcardenasc93/work-at-olist/app/middlewares"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+const stdoutName = "-"
+
+// pageSize is the number of rows fetched from the database at a time.
+const pageSize = 1000
+
+const (
+	entityAuthors = "authors"
+	entityBooks   = "books"
+)
+
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+	formatSQL   = "sql"
+)
+
+type config struct {
+	dbName string
+	entity string
+	format string
+	out    string
+	query  url.Values
+}
+
+func parseFlags() (*config, error) {
+	cfg := new(config)
+	var query string
+	flag.StringVar(&cfg.dbName, "db", "", "SQLite database file (defaults to $dbName)")
+	flag.StringVar(&cfg.entity, "entity", entityAuthors, "Data to export: authors or books")
+	flag.StringVar(&cfg.format, "format", formatCSV, "Output format: csv, jsonl or sql")
+	flag.StringVar(&cfg.out, "out", stdoutName, "Output file path, use - to write to stdout")
+	flag.StringVar(&query, "query", "", "Filters with the same syntax as the API query params, e.g. name=python&edition=2")
+	flag.Parse()
+
+	if cfg.entity != entityAuthors && cfg.entity != entityBooks {
+		return nil, fmt.Errorf("invalid -entity value %q", cfg.entity)
+	}
+	switch cfg.format {
+	case formatCSV, formatJSONL, formatSQL:
+	default:
+		return nil, fmt.Errorf("invalid -format value %q", cfg.format)
+	}
+	var err error
+	if cfg.query, err = url.ParseQuery(query); err != nil {
+		return nil, fmt.Errorf("invalid -query value: %w", err)
+	}
+	if cfg.dbName, err = db.ResolveName(cfg.dbName); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func main() {
+	cfg, err := parseFlags()
+	if err != nil {
+		flag.Usage()
+		log.Fatal(err)
+	}
+
+	var out io.WriteCloser = os.Stdout
+	if cfg.out != stdoutName {
+		if out, err = os.Create(cfg.out); err != nil {
+			log.Fatal(err)
+		}
+	}
+	defer out.Close()
+	buffered := bufio.NewWriter(out)
+
+	store, err := db.OpenSQLiteDB(cfg.dbName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = store.Setup(); err != nil {
+		log.Fatal(err)
+	}
+
+	w := newWriter(cfg.format, buffered)
+	var total int
+	if cfg.entity == entityAuthors {
+		total, err = exportAuthors(store, cfg.query, w)
+	} else {
+		total, err = exportBooks(store, cfg.query, w)
+	}
+	if err == nil {
+		err = w.close()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Done! %d %s exported", total, cfg.entity)
+}
+
+// exportAuthors pages through the authors matching query with the same keyset
+// pagination used by the API, so memory use doesn't depend on the table size.
+// The cursors carry the sort of the query like the ones of the API.
+func exportAuthors(store db.ApiDB, query url.Values, w writer) (int, error) {
+	var total int
+	if err := w.beginAuthors(); err != nil {
+		return total, err
+	}
+	pagination := &m.PaginationVals{Limit: pageSize}
+	for {
+		authors, err := store.FetchAuthors(pagination, query)
+		if err != nil {
+			return total, err
+		}
+		for _, author := range authors {
+			if err = w.author(author); err != nil {
+				return total, err
+			}
+		}
+		total += len(authors)
+		if len(authors) < pageSize {
+			return total, nil
+		}
+		last := authors[len(authors)-1]
+		pagination.Cursor = &m.Cursor{
+			Sort: query.Get(db.SortKey),
+			Key:  db.AuthorCursorKey(query.Get(db.SortKey), last),
+			Id:   float64(last.Id),
+		}
+	}
+}
+
+func exportBooks(store db.ApiDB, query url.Values, w writer) (int, error) {
+	var total int
+	if err := w.beginBooks(); err != nil {
+		return total, err
+	}
+	pagination := &m.PaginationVals{Limit: pageSize}
+	for {
+		books, err := store.FetchBooks(pagination, query)
+		if err != nil {
+			return total, err
+		}
+		for _, book := range books {
+			if err = w.book(book); err != nil {
+				return total, err
+			}
+		}
+		total += len(books)
+		if len(books) < pageSize {
+			return total, nil
+		}
+		last := books[len(books)-1]
+		pagination.Cursor = &m.Cursor{
+			Sort: query.Get(db.SortKey),
+			Key:  db.BookCursorKey(query.Get(db.SortKey), last),
+			Id:   last.Id,
+		}
+	}
+}
+
+// writer serializes the exported rows in one output format.
+type writer interface {
+	beginAuthors() error
+	author(*models.Author) error
+	beginBooks() error
+	book(*models.Book) error
+	close() error
+}
+
+func newWriter(format string, out io.Writer) writer {
+	switch format {
+	case formatJSONL:
+		return newJSONLWriter(out)
+	case formatSQL:
+		return newSQLWriter(out)
+	}
+	return newCSVWriter(out)
+}
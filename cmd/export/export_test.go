@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+func TestExportSortedPages(t *testing.T) {
+	store := db.NewMockDB()
+	authors := []*models.Author{}
+	books := []*models.Book{}
+	for i := 1; i <= pageSize+500; i++ {
+		// Repeated names, so the pages break among rows tied on the sort
+		authors = append(authors, models.NewAuthor(uint64(i), fmt.Sprintf("Author %03d", i%300)))
+		books = append(books, models.NewBook(float64(i), fmt.Sprintf("Book %d", i), float64(i%3+1), float64(1900+i%100), []float64{1}))
+	}
+	store.SetAuthors(authors)
+	store.SetBooks(books)
+
+	cases := []struct {
+		entity string
+		query  string
+	}{
+		{entityAuthors, "sort=-name"},
+		{entityAuthors, ""},
+		{entityBooks, "sort=-publication_year,edition"},
+		{entityBooks, "sort=name&edition=2"},
+	}
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		out := new(bytes.Buffer)
+		w := newJSONLWriter(out)
+		var total int
+		var err error
+		if c.entity == entityAuthors {
+			total, err = exportAuthors(store, query, w)
+		} else {
+			total, err = exportBooks(store, query, w)
+		}
+		if err != nil {
+			t.Fatalf("Expected %s %q to be exported but got %v", c.entity, c.query, err)
+		}
+
+		ids := []float64{}
+		dec := json.NewDecoder(out)
+		for dec.More() {
+			row := map[string]any{}
+			if err = dec.Decode(&row); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, row["id"].(float64))
+		}
+		expected := len(authors)
+		if query.Has("edition") {
+			expected = len(books) / 3
+		}
+		if total != expected || len(ids) != expected {
+			t.Errorf("Expected %d %s for %q but got %d written and %d counted", expected, c.entity, c.query, len(ids), total)
+		}
+		sort.Float64s(ids)
+		for i := 1; i < len(ids); i++ {
+			if ids[i] == ids[i-1] {
+				t.Errorf("Expected no repeated %s for %q but got %v twice", c.entity, c.query, ids[i])
+				break
+			}
+		}
+	}
+}
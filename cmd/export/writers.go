@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jcardenasc93/work-at-olist/app/models"
+)
+
+// authorsSep joins the book authors ids in a single CSV column, as expected by
+// the books import command.
+const authorsSep = ";"
+
+// csvWriter writes the authors with the columns read by the authors import
+// command and the books with the ones read by the books import command.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(out io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(out)}
+}
+
+func (c *csvWriter) beginAuthors() error {
+	return c.w.Write([]string{"id", "name", "birth_year", "nationality", "external_id"})
+}
+
+func (c *csvWriter) author(a *models.Author) error {
+	birthYear := ""
+	if a.BirthYear != nil {
+		birthYear = strconv.Itoa(*a.BirthYear)
+	}
+	return c.w.Write([]string{
+		strconv.FormatUint(a.Id, 10),
+		a.Name,
+		birthYear,
+		stringOrEmpty(a.Nationality),
+		stringOrEmpty(a.ExternalId),
+	})
+}
+
+func (c *csvWriter) beginBooks() error {
+	return c.w.Write([]string{"id", "name", "edition", "publication_year", "authors"})
+}
+
+func (c *csvWriter) book(b *models.Book) error {
+	authors := make([]string, len(b.Authors))
+	for i, id := range b.Authors {
+		authors[i] = formatNumber(id)
+	}
+	return c.w.Write([]string{
+		formatNumber(b.Id),
+		b.Name,
+		formatNumber(b.Edition),
+		formatNumber(b.PubYear),
+		strings.Join(authors, authorsSep),
+	})
+}
+
+func (c *csvWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlWriter writes every row as the JSON object returned by the API.
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(out io.Writer) *jsonlWriter {
+	return &jsonlWriter{enc: json.NewEncoder(out)}
+}
+
+func (j *jsonlWriter) beginAuthors() error { return nil }
+
+func (j *jsonlWriter) author(a *models.Author) error { return j.enc.Encode(a) }
+
+func (j *jsonlWriter) beginBooks() error { return nil }
+
+func (j *jsonlWriter) book(b *models.Book) error { return j.enc.Encode(b) }
+
+func (j *jsonlWriter) close() error { return nil }
+
+// sqlWriter writes a portable SQL script that creates the tables and inserts
+// the rows, including the author_book relationships of the exported books.
+type sqlWriter struct {
+	out io.Writer
+	err error
+}
+
+func newSQLWriter(out io.Writer) *sqlWriter {
+	return &sqlWriter{out: out}
+}
+
+// printf writes to the output, keeping the first error so callers can check
+// it once per row.
+func (s *sqlWriter) printf(format string, args ...any) error {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.out, format, args...)
+	}
+	return s.err
+}
+
+func (s *sqlWriter) beginAuthors() error {
+	s.printf("BEGIN TRANSACTION;\n")
+	return s.printf(`CREATE TABLE IF NOT EXISTS author (
+    id INTEGER PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    name_key VARCHAR(64) UNIQUE,
+    birth_year INTEGER,
+    nationality VARCHAR(64),
+    external_id VARCHAR(64)
+);
+`)
+}
+
+func (s *sqlWriter) author(a *models.Author) error {
+	var birthYear any
+	if a.BirthYear != nil {
+		birthYear = *a.BirthYear
+	}
+	return s.printf("INSERT INTO author (id, name, name_key, birth_year, nationality, external_id) VALUES (%d, %s, %s, %s, %s, %s);\n",
+		a.Id, sqlValue(a.Name), sqlValue(a.NameKey), sqlValue(birthYear),
+		sqlValue(a.Nationality), sqlValue(a.ExternalId))
+}
+
+func (s *sqlWriter) beginBooks() error {
+	s.printf("BEGIN TRANSACTION;\n")
+	return s.printf(`CREATE TABLE IF NOT EXISTS book (
+    id INTEGER PRIMARY KEY,
+    name VARCHAR(80) NOT NULL,
+    edition INTEGER NOT NULL,
+    publication_year INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS author_book (
+    author_id INTEGER,
+    book_id INTEGER
+);
+`)
+}
+
+func (s *sqlWriter) book(b *models.Book) error {
+	s.printf("INSERT INTO book (id, name, edition, publication_year) VALUES (%s, %s, %s, %s);\n",
+		formatNumber(b.Id), sqlValue(b.Name), formatNumber(b.Edition), formatNumber(b.PubYear))
+	for _, author := range b.Authors {
+		s.printf("INSERT INTO author_book (author_id, book_id) VALUES (%s, %s);\n", formatNumber(author), formatNumber(b.Id))
+	}
+	return s.err
+}
+
+func (s *sqlWriter) close() error {
+	return s.printf("COMMIT;\n")
+}
+
+// sqlValue returns the SQL literal of value, escaping the quotes of strings.
+func sqlValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case *string:
+		if v == nil {
+			return "NULL"
+		}
+		return sqlValue(*v)
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return fmt.Sprint(value)
+}
+
+func stringOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+	"github.com/jcardenasc93/work-at-olist/app/models"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The files in testdata are the exports of the fixtures below. The authors
+// and books import commands read them back in their tests, so a change in
+// the columns written here that the importers don't follow breaks both.
+func fixtureStore() *db.MockDB {
+	birthYear := 1839
+	nationality := "BR"
+	externalId := "Q311145"
+	store := db.NewMockDB()
+	store.SetAuthors([]*models.Author{
+		{Id: 1, Name: "Machado de Assis", BirthYear: &birthYear, Nationality: &nationality, ExternalId: &externalId},
+		{Id: 2, Name: "O'Brien, Flann"},
+		{Id: 3, Name: `Dwayne "The Rock" Johnson`},
+	})
+	store.SetBooks([]*models.Book{
+		models.NewBook(1, "Dom Casmurro", 1, 1899, []float64{1}),
+		models.NewBook(2, "At Swim-Two-Birds, \"annotated\"", 2, 1939, []float64{2, 3}),
+	})
+	return store
+}
+
+func TestWritersOutput(t *testing.T) {
+	cases := []struct {
+		format string
+		entity string
+		file   string
+	}{
+		{formatCSV, entityAuthors, "authors.csv"},
+		{formatJSONL, entityAuthors, "authors.jsonl"},
+		{formatCSV, entityBooks, "books.csv"},
+		{formatJSONL, entityBooks, "books.jsonl"},
+	}
+	for _, c := range cases {
+		store := fixtureStore()
+		out := new(bytes.Buffer)
+		w := newWriter(c.format, out)
+		var err error
+		if c.entity == entityAuthors {
+			_, err = exportAuthors(store, nil, w)
+		} else {
+			_, err = exportBooks(store, nil, w)
+		}
+		if err == nil {
+			err = w.close()
+		}
+		if err != nil {
+			t.Fatalf("Expected %s to be exported as %s but got %v", c.entity, c.format, err)
+		}
+
+		expected, err := os.ReadFile(filepath.Join("testdata", c.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), expected) {
+			t.Errorf("Expected the %s export to match testdata/%s but got\n%s", c.entity, c.file, out)
+		}
+	}
+}
+
+// TestSQLExportLoads loads the SQL export of a database migrated to the
+// name_key column, where the duplicated authors kept a NULL key.
+func TestSQLExportLoads(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "old.db")
+	old, err := sql.Open("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	_, err = old.Exec(`CREATE TABLE author (id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(64) NOT NULL);
+                       INSERT INTO author (name) VALUES ('Homer'), ('homer'), ('Virgil')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := db.OpenSQLiteDB(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.CreateAuthorTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	w := newSQLWriter(out)
+	if _, err = exportAuthors(store, nil, w); err == nil {
+		err = w.close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := sql.Open("sqlite3", filepath.Join(dir, "new.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	if _, err = loaded.Exec(out.String()); err != nil {
+		t.Fatalf("Expected the export to load but got %v\n%s", err, out)
+	}
+	var keys int
+	if err = loaded.QueryRow(`SELECT COUNT(name_key) FROM author`).Scan(&keys); err != nil || keys != 2 {
+		t.Errorf("Expected the stored keys to be exported, NULL included, but got %d keys, %v", keys, err)
+	}
+}
@@ -0,0 +1,134 @@
+// Package rejects writes the rows rejected by the import commands to a CSV
+// file, so they can be fixed and imported again.
+package rejects
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Writer stores the rejected records in a CSV file annotated with their line
+// number and the rejection reason. The file is only created when the first
+// record is rejected.
+type Writer struct {
+	path   string
+	append bool
+	file   *os.File
+	w      *csv.Writer
+}
+
+// NewWriter returns a writer for the file in path. In append mode the records
+// are added to the existing file, as resumed imports do.
+func NewWriter(path string, appendMode bool) *Writer {
+	return &Writer{
+		path:   path,
+		append: appendMode,
+	}
+}
+
+func (r *Writer) open() error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if r.append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(r.path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.w = csv.NewWriter(file)
+	if info.Size() == 0 {
+		return r.w.Write([]string{"line", "reason", "record"})
+	}
+	return nil
+}
+
+// Add writes a rejected record, whose fields follow the line and the reason.
+func (r *Writer) Add(line int, reason string, record []string) error {
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	row := append([]string{strconv.Itoa(line), reason}, record...)
+	return r.w.Write(row)
+}
+
+// Flush writes the buffered records to the file.
+func (r *Writer) Flush() error {
+	if r.w == nil {
+		return nil
+	}
+	r.w.Flush()
+	return r.w.Error()
+}
+
+func (r *Writer) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Trim removes from the file in path the records after line. A resumed import
+// calls it before appending, since the interrupted run may have rejected rows
+// past its last committed batch, which are read again.
+func Trim(path string, line int) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	w := csv.NewWriter(out)
+	for first := true; ; first = false {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// The header is kept
+		if !first {
+			rowLine, err := strconv.Atoi(row[0])
+			if err != nil || rowLine > line {
+				continue
+			}
+		}
+		if err = w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,89 @@
+package rejects
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejects.csv")
+
+	empty := NewWriter(path, false)
+	if err := empty.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected no file without rejected rows but got %v", err)
+	}
+
+	w := NewWriter(path, false)
+	if err := w.Add(2, "blank name", []string{"", "1950"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Resumed imports append to the file without repeating the header
+	w = NewWriter(path, true)
+	if err := w.Add(7, "malformed row", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"line", "reason", "record"},
+		{"2", "blank name", "", "1950"},
+		{"7", "malformed row"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Expected rows %v but got %v", expected, rows)
+	}
+
+	// A new import starts the file over
+	w = NewWriter(path, false)
+	if err = w.Add(3, "too long", []string{"x"}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	data, _ := os.ReadFile(path)
+	if string(data) != "line,reason,record\n3,too long,x\n" {
+		t.Errorf("Expected the file to be truncated but got %q", data)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejects.csv")
+	if err := Trim(path, 10); err != nil {
+		t.Fatalf("Expected a missing file to be ignored but got %v", err)
+	}
+
+	w := NewWriter(path, false)
+	w.Add(3, "blank name", []string{""})
+	w.Add(12, "malformed row", []string{"a\nb"})
+	w.Add(15, "blank name", []string{""})
+	w.Close()
+	if err := Trim(path, 12); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	expected := "line,reason,record\n3,blank name,\n12,malformed row,\"a\nb\"\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q after trimming but got %q", expected, data)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/jcardenasc93/work-at-olist/app/db"
+)
+
+const defaultRetention = 30 * 24 * time.Hour
+
+type config struct {
+	dbName    string
+	retention time.Duration
+}
+
+func parseFlags() (*config, error) {
+	cfg := new(config)
+	flag.StringVar(&cfg.dbName, "db", "", "SQLite database file (defaults to $dbName)")
+	flag.DurationVar(&cfg.retention, "retention", defaultRetention, "How long trashed books are kept before being purged, e.g. 720h")
+	flag.Parse()
+
+	if cfg.retention < 0 {
+		return nil, errors.New("-retention can't be negative")
+	}
+	var err error
+	if cfg.dbName, err = db.ResolveName(cfg.dbName); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// main permanently removes the books that have been in the trash for longer
+// than the retention period, along with their authors relationships.
+func main() {
+	cfg, err := parseFlags()
+	if err != nil {
+		flag.Usage()
+		log.Fatal(err)
+	}
+
+	store, err := db.OpenSQLiteDB(cfg.dbName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = store.Setup(); err != nil {
+		log.Fatal(err)
+	}
+
+	before := time.Now().Add(-cfg.retention)
+	purged, err := store.PurgeBooks(context.Background(), before)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Done! %d books trashed before %s purged", purged, before.UTC().Format(time.RFC3339))
+}
@@ -1,10 +1,11 @@
 module github.com/jcardenasc93/work-at-olist
 
-go 1.19
+go 1.22
 
 require (
 	github.com/go-chi/chi v1.5.4
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.18.0
 	github.com/mattn/go-sqlite3 v1.14.16
 )
 